@@ -0,0 +1,226 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"slices"
+	"text/tabwriter"
+	"time"
+
+	"xlpdok/pkg/notify"
+	"xlpdok/pkg/spk"
+	"xlpdok/pkg/status"
+	"xlpdok/pkg/units"
+)
+
+// UpdateInfo 上游版本检查结果
+type UpdateInfo struct {
+	Current   string    `json:"current"`
+	Latest    string    `json:"latest"`
+	Available bool      `json:"available"`
+	Url       string    `json:"url"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// 读取单个镜像 INFO 的超时
+const remoteInfoTimeout = 30 * time.Second
+
+// remoteInfo 按镜像顺序读取远程 SPK 的 INFO，返回第一个成功的结果和对应地址
+func remoteInfo(ctx context.Context, spkUrls []string) (info spk.Info, spkUrl string, err error) {
+	var errs []error
+	for _, spkUrl = range spkUrls {
+		if err = ctx.Err(); err != nil {
+			break
+		}
+		actx, cancel := context.WithTimeout(ctx, remoteInfoTimeout)
+		info, err = spk.RemoteInfo(actx, spkUrl)
+		cancel()
+		if err == nil {
+			return
+		}
+		slog.DebugContext(ctx, "remote spk info", "url", spkUrl, "err", err)
+		errs = append(errs, fmt.Errorf("%s: %w", spkUrl, err))
+	}
+	return nil, "", cmp.Or(errors.Join(errs...), err)
+}
+
+// checkUpdate 按镜像顺序读取远程 SPK 的 INFO，与本地版本比较
+func checkUpdate(ctx context.Context, cfg Config) (u UpdateInfo, err error) {
+	u = UpdateInfo{Current: readVersion(), CheckedAt: time.Now()}
+	info, spkUrl, err := remoteInfo(ctx, cfg.SpkUrl)
+	if err != nil {
+		return
+	}
+	u.Latest, u.Url = info.Version(), spkUrl
+	u.Available = u.Latest != "" && spk.CompareVersion(u.Latest, u.Current) > 0
+	return
+}
+
+// watchUpdate 定期检查上游版本，结果发布到状态接口
+func watchUpdate(ctx context.Context, cfg Config) {
+	ticker := time.NewTicker(cfg.UpdateCheck)
+	defer ticker.Stop()
+	for {
+		if u, err := checkUpdate(ctx, cfg); err != nil {
+			slog.WarnContext(ctx, "check update", "err", err)
+		} else {
+			status.Set("update", u)
+			if u.Available {
+				slog.InfoContext(ctx, "update available", "current", u.Current, "latest", u.Latest)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// cmdCheckUpdate 子命令 check-update
+func cmdCheckUpdate(ctx context.Context, cfg Config) error {
+	u, err := checkUpdate(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	if cfg.Output == "json" {
+		return printJSON(u)
+	}
+	fmt.Printf("current: %s\nlatest:  %s\nurl:     %s\n", u.Current, u.Latest, u.Url)
+	if u.Available {
+		fmt.Println("update available, run `xlpdok upgrade` to install it")
+	} else {
+		fmt.Println("already up to date")
+	}
+	return nil
+}
+
+// cmdUpgrade 子命令 upgrade，下载、校验并安装上游新版本，重启后生效
+func cmdUpgrade(ctx context.Context, cfg Config) error {
+	u, err := checkUpdate(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	if !u.Available {
+		fmt.Printf("already up to date: %s\n", u.Current)
+		return nil
+	}
+
+	if cfg.SpkPin != "" {
+		return fmt.Errorf("spk is pinned to %s, unset spk_pin to upgrade", cfg.SpkPin)
+	}
+
+	store := spkStore()
+	if err = store.Migrate(ctx); err != nil {
+		return err
+	}
+	version, err := store.Install(ctx, cfg.SpkUrl, append(spkOptions(cfg), spk.WithRefresh())...)
+	if err != nil {
+		return err
+	}
+	if err = store.Use(ctx, version); err != nil {
+		return err
+	}
+	store.GC(ctx, cfg.SpkKeep)
+
+	cfg.Notifiers.Notify(ctx, notify.Event{Name: notify.SpkUpdated, Data: map[string]any{"version": readVersion(), "old_version": u.Current}})
+	fmt.Printf("upgraded %s -> %s, restart xlpdok to apply\n", u.Current, readVersion())
+	return nil
+}
+
+// cmdRollback 子命令 rollback，切换回上一个 SPK 版本，重启后生效
+func cmdRollback(ctx context.Context, cfg Config) error {
+	store := spkStore()
+	current := store.Active()
+	version, err := store.Rollback(ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("rolled back %s -> %s, restart xlpdok to apply\n", current, version)
+	if cfg.SpkPin != "" && cfg.SpkPin != version {
+		fmt.Printf("warning: spk is pinned to %s, it will be switched back on next start\n", cfg.SpkPin)
+	}
+	return nil
+}
+
+// cmdInspect 子命令 inspect，列出 SPK 的 INFO、文件清单和会被提取的文件
+func cmdInspect(ctx context.Context, cfg Config, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: xlpdok inspect [-o json] FILE|URL")
+	}
+
+	r, err := spk.Inspect(ctx, args[0], spk.WithFilter(spkFilter(cfg)), spk.WithSignature(cfg.SpkKeyring, false))
+	if err != nil {
+		return err
+	}
+	if cfg.Output == "json" {
+		return printJSON(r)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "source:\t%s\nsize:\t%s\nsha256:\t%s\nsignature:\t%s\n", r.Source, units.HumanBytes(r.Size), r.Sha256, r.Signature)
+
+	fmt.Fprintln(w, "\nINFO:")
+	for _, k := range slices.Sorted(maps.Keys(r.Info)) {
+		fmt.Fprintf(w, "  %s\t%s\n", k, r.Info[k])
+	}
+
+	printEntries := func(title string, entries []spk.Entry) {
+		fmt.Fprintf(w, "\n%s:\n", title)
+		for _, e := range entries {
+			name := e.Name
+			if e.Link != "" {
+				name += " -> " + e.Link
+			}
+			fmt.Fprintf(w, "  %s\t%d\t%s\n", e.Mode, e.Size, name)
+		}
+	}
+	printEntries("FILES", r.Files)
+	printEntries("PACKAGE", r.Package)
+
+	fmt.Fprintln(w, "\nEXTRACT:")
+	for _, name := range r.Extract {
+		fmt.Fprintf(w, "  %s\n", name)
+	}
+	return w.Flush()
+}
+
+func printJSON(v any) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// cmdInstall 子命令 install，从指定来源(地址、dir://、oci:// 或 - 标准输入)安装 SPK 并切换，重启后生效
+func cmdInstall(ctx context.Context, cfg Config, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: xlpdok install SOURCE")
+	}
+
+	store := spkStore()
+	if err := store.Migrate(ctx); err != nil {
+		return err
+	}
+	current := store.Active()
+	version, err := store.Install(ctx, args, spkOptions(cfg)...)
+	if err != nil {
+		return err
+	}
+	if err = store.Use(ctx, version); err != nil {
+		return err
+	}
+	store.GC(ctx, cfg.SpkKeep, cfg.SpkPin)
+
+	fmt.Printf("installed %s (was %s), restart xlpdok to apply\n", version, cmp.Or(current, "none"))
+	if cfg.SpkPin != "" && cfg.SpkPin != version {
+		fmt.Printf("warning: spk is pinned to %s, it will be switched back on next start\n", cfg.SpkPin)
+	}
+	return nil
+}
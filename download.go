@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"xlpdok/pkg/disk"
+	"xlpdok/pkg/notify"
+	"xlpdok/pkg/rule"
+	"xlpdok/pkg/units"
+	"xlpdok/pkg/unpack"
+	"xlpdok/pkg/watch"
+	"xlpdok/pkg/webhook"
+)
+
+// watchDownloads 监听下载目录，文件下载完成后执行处理规则和自动解压，然后触发回调和推送通知
+func watchDownloads(ctx context.Context, cfg Config) {
+	err := watch.Completed(ctx, cfg.DirDownload, tempSuffixes(cfg), func(f watch.File) {
+		// 解压和规则移动过程中的临时文件
+		if unpack.Staging(f.Path) || rule.Staging(f.Path) {
+			return
+		}
+		slog.InfoContext(ctx, "download completed", "path", f.Path, "size", f.Size, "duration", f.Duration)
+		f.Path = cfg.Rules.Apply(ctx, f.Path, f.Size)
+		if cfg.Unpack != nil && unpack.Supported(f.Path) {
+			if dst, err := cfg.Unpack.Extract(ctx, f.Path); err != nil {
+				slog.WarnContext(ctx, "extract archive", "path", f.Path, "err", err)
+			} else {
+				slog.InfoContext(ctx, "extract archive", "path", f.Path, "target", dst)
+			}
+		}
+		webhook.Send(ctx, cfg.Webhooks, "download.completed", webhook.Completed{
+			Event:    "download.completed",
+			Path:     f.Path,
+			Size:     f.Size,
+			Duration: f.Duration.Seconds(),
+			Time:     time.Now(),
+		})
+		cfg.Notifiers.Notify(ctx, notify.Event{Name: notify.DownloadCompleted, Instance: cfg.Name, Data: map[string]any{
+			"path":     f.Path,
+			"size":     units.HumanBytes(f.Size),
+			"duration": f.Duration.Round(time.Second).String(),
+		}})
+	})
+	if err != nil {
+		slog.WarnContext(ctx, "watch downloads", "err", err)
+	}
+}
+
+// tempSuffixes 下载中临时文件的后缀
+func tempSuffixes(cfg Config) []string {
+	if len(cfg.TempSuffixes) > 0 {
+		return cfg.TempSuffixes
+	}
+	return watch.DefaultTempSuffixes
+}
+
+// taskExists 临时文件对应的迅雷任务是否仍存在：文件仍被进程打开，或账号目录中的任务数据仍引用该文件名。
+// 无法确定时按存在处理，避免误删
+func taskExists(cfg Config) func(path string) bool {
+	return func(path string) bool {
+		if fileOpened(path, func(link string) string { return hostPath(cfg, link) }) {
+			return true
+		}
+
+		name := filepath.Base(path)
+		for _, suffix := range tempSuffixes(cfg) {
+			name = strings.TrimSuffix(name, suffix)
+		}
+		find, err := dataReferences(cfg.DirData, []byte(name))
+		return find || err != nil
+	}
+}
+
+// fileOpened 是否有进程打开了 path，resolve 将进程看到的路径转换为宿主路径
+func fileOpened(path string, resolve func(string) string) bool {
+	fds, _ := filepath.Glob("/proc/[0-9]*/fd/*")
+	for _, fd := range fds {
+		if link, err := os.Readlink(fd); err == nil && resolve(link) == path {
+			return true
+		}
+	}
+	return false
+}
+
+// hostPath 将迅雷看到的 /downloads/名称 下的路径转换为宿主上的下载目录路径，其他路径原样返回
+func hostPath(cfg Config, path string) string {
+	for dir, name := range cfg.DownloadNames {
+		bind := filepath.Join(DIR_NAMED_DOWNLOADS, name)
+		if path == bind {
+			return dir
+		}
+		if rel, find := strings.CutPrefix(path, bind+"/"); find {
+			return filepath.Join(dir, rel)
+		}
+	}
+	return path
+}
+
+// dataReferences 账号目录中是否有文件包含 name
+func dataReferences(dir string, name []byte) (find bool, err error) {
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || find || !d.Type().IsRegular() {
+			return err
+		}
+		find, err = fileContains(path, name)
+		return err
+	})
+	return
+}
+
+// fileContains 分块查找文件内容，块之间保留 len(sub)-1 字节避免跨块漏查
+func fileContains(path string, sub []byte) (find bool, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	buf := make([]byte, 0, 64*1024+len(sub))
+	for {
+		n, e := f.Read(buf[len(buf):cap(buf)])
+		buf = buf[:len(buf)+n]
+		if bytes.Contains(buf, sub) {
+			return true, nil
+		}
+		if e != nil {
+			if e == io.EOF {
+				e = nil
+			}
+			return false, e
+		}
+		if keep := len(sub) - 1; len(buf) > keep {
+			buf = buf[:copy(buf, buf[len(buf)-keep:])]
+		}
+	}
+}
+
+// guardDisk 检查下载目录剩余空间：低于通知阈值时推送，低于暂停阈值时通过 hold 要求正常停止迅雷，
+// 空间恢复后重新启动。面板和其他任务继续运行
+func guardDisk(ctx context.Context, cfg Config, hold chan<- bool) {
+	held, notified := false, disk.OK
+	cfg.DiskGuard.Run(ctx, cfg.DirDownload, func(level disk.Level, states []disk.DirState) error {
+		// 停止未生效时会以同一级别再次调用，只在级别变化时推送
+		if level >= disk.Notify && level != notified {
+			cfg.Notifiers.Notify(ctx, notify.Event{Name: notify.DiskLow, Instance: cfg.Name, Data: map[string]any{"level": level.String(), "dirs": states}})
+		}
+		notified = level
+
+		if pause := level >= disk.Pause; pause != held {
+			select {
+			case hold <- pause:
+				held = pause
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"xlpdok/pkg/spk"
+	"xlpdok/pkg/status"
+)
+
+// spkProgress 下载进度输出：终端中显示进度条，否则定期输出日志；同时发布到状态接口
+func spkProgress() []spk.Reporter {
+	console := spk.LogProgress(10 * time.Second)
+	if stat, err := os.Stderr.Stat(); err == nil && stat.Mode()&os.ModeCharDevice != 0 {
+		console = spk.BarProgress(os.Stderr)
+	}
+
+	return []spk.Reporter{console, func(ctx context.Context, p spk.Progress) {
+		status.Set("spk_download", map[string]any{
+			"url":         p.Url,
+			"current":     p.Current,
+			"total":       p.Total,
+			"percent":     p.Percent(),
+			"rate":        int64(p.Rate),
+			"eta_seconds": int64(p.ETA.Seconds()),
+			"done":        p.Done,
+			"err":         p.Err,
+		})
+	}}
+}
+
+// setInstallStatus 发布 SPK 安装状态: downloading、installed、failed
+func setInstallStatus(state, version string, err error) {
+	v := map[string]any{"state": state, "version": version}
+	if err != nil {
+		v["err"] = err.Error()
+	}
+	status.Set("spk_install", v)
+}
+
+// 安装页面查询待安装版本的总超时，版本只用于展示，不应拖慢启动
+const installingInfoTimeout = 10 * time.Second
+
+// serveInstalling 下载 SPK 期间在 cfg.Listen 上提供状态接口和安装进度页面，返回的函数关闭服务。
+// 端口被占用时只记录日志
+func serveInstalling(ctx context.Context, cfg Config) (stop func()) {
+	version := ""
+	ictx, cancel := context.WithTimeout(ctx, installingInfoTimeout)
+	if info, _, err := remoteInfo(ictx, cfg.SpkUrl); err == nil {
+		version = info.Version()
+	}
+	cancel()
+	setInstallStatus("downloading", version, nil)
+
+	l, err := net.Listen("tcp", cmp.Or(cfg.Listen, ":2345"))
+	if err != nil {
+		slog.WarnContext(ctx, "installing page not available", "err", err)
+		return func() {}
+	}
+
+	mux := chi.NewMux()
+	mux.Get("/xlpdok/status", status.Handler().ServeHTTP)
+	mux.Get("/xlpdok/events", status.Events().ServeHTTP)
+	mux.NotFound(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Retry-After", "5")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(installingPage))
+	})
+
+	s := &http.Server{Handler: mux, BaseContext: func(net.Listener) context.Context { return ctx }}
+	go s.Serve(l)
+	slog.InfoContext(ctx, "installing page started", "listen", l.Addr().String())
+
+	// SSE 连接不会主动结束，直接关闭而不是 Shutdown
+	return func() { s.Close() }
+}
+
+const installingPage = `<!doctype html>
+<html><head><meta charset="utf-8"><meta name="viewport" content="width=device-width,initial-scale=1"><title>xlpdok</title>
+<style>body{font-family:sans-serif;max-width:32em;margin:5em auto;padding:0 1em}progress{width:100%}</style></head>
+<body><h3 id="title">正在安装迅雷</h3><progress id="bar"></progress><p id="detail"></p>
+<script>
+const es = new EventSource("/xlpdok/events"), $ = id => document.getElementById(id);
+let version = "";
+es.addEventListener("spk_install", e => {
+  const s = JSON.parse(e.data);
+  version = s.version || version;
+  $("title").textContent = "正在安装迅雷 " + version;
+  if (s.state === "failed") $("detail").textContent = "安装失败: " + s.err;
+  if (s.state === "installed") { es.close(); setTimeout(() => location.reload(), 3000); }
+});
+es.addEventListener("spk_download", e => {
+  const p = JSON.parse(e.data), mb = n => (n / 1048576).toFixed(1) + " MiB";
+  if (p.percent >= 0) { $("bar").max = 100; $("bar").value = p.percent; }
+  $("title").textContent = "正在安装迅雷 " + version + (p.percent >= 0 ? ": " + Math.floor(p.percent) + "%" : "");
+  $("detail").textContent = mb(p.current) + (p.total > 0 ? " / " + mb(p.total) : "") + ", " + mb(p.rate) + "/s" + (p.eta_seconds > 0 ? ", 剩余 " + p.eta_seconds + " 秒" : "");
+});
+es.onerror = () => setTimeout(() => location.reload(), 5000);
+</script></body></html>
+`
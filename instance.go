@@ -0,0 +1,213 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"xlpdok/pkg/fo"
+	"xlpdok/pkg/hook"
+	"xlpdok/pkg/sys"
+)
+
+const (
+	DIR_INSTANCES = "/var/packages/pan-xunlei-com/instances" // 多实例的私有 var 目录
+
+	ENV_INSTANCE_CONFIG = "XL_INSTANCE_CONFIG_FD" // 子进程从该文件描述符读取实例配置(JSON)，配置含密钥，不经环境变量传递
+
+	exitMountLost = 3 // 实例因挂载丢失停止，不再重启
+
+	instanceStopTimeout = 2 * time.Minute // 停止时等待实例子进程退出的时间，超时后强制结束
+)
+
+// 实例异常退出后的重启间隔，从 instanceBackoffMin 开始翻倍；稳定运行超过 instanceBackoffMax 后重置
+const (
+	instanceBackoffMin = time.Second
+	instanceBackoffMax = time.Minute
+)
+
+// Instance 单个迅雷账号实例，未设置的字段继承顶层配置
+type Instance struct {
+	Name        string   `json:"name"`
+	Listen      string   `json:"listen,omitempty"`
+	DirDownload []string `json:"dir_download,omitempty"`
+	DirData     string   `json:"dir_data,omitempty"`
+	Uid         int      `json:"uid,omitempty"` // 实例的账号、下载和 var 目录属于该用户；共享的 SPK 目录属于顶层 uid/gid，实例只需读取和执行
+	Gid         int      `json:"gid,omitempty"`
+}
+
+var reInstanceName = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// instanceConfigs 展开为每个实例的完整配置，并检查名称、面板地址、账号目录和下载目录不冲突。
+// 下载目录的监听、规则、清理等在各实例中分别运行，共享下载目录会重复处理同一文件
+func instanceConfigs(cfg Config) (configs []Config, err error) {
+	seen := map[string]string{}
+	for _, inst := range cfg.Instances {
+		if !reInstanceName.MatchString(inst.Name) {
+			return nil, fmt.Errorf("instance name is invalid: %q", inst.Name)
+		}
+
+		c := cfg
+		c.Instances, c.ConfigFile = nil, ""
+		c.Name = inst.Name
+		c.Listen = cmp.Or(inst.Listen, cfg.Listen)
+		c.DirData = cmp.Or(inst.DirData, filepath.Join(cfg.DirData, inst.Name))
+		c.Uid, c.Gid = cmp.Or(inst.Uid, cfg.Uid), cmp.Or(inst.Gid, cfg.Gid)
+		if len(inst.DirDownload) > 0 {
+			c.DirDownload, c.DownloadNames = inst.DirDownload, nil
+		}
+		if err = configNormalize(&c); err != nil {
+			return
+		}
+
+		keys := []string{"name=" + c.Name, "listen=" + c.Listen, "dir_data=" + c.DirData}
+		for _, dir := range c.DirDownload {
+			keys = append(keys, "dir_download="+dir)
+		}
+		for _, key := range keys {
+			if other, find := seen[key]; find {
+				k, v, _ := strings.Cut(key, "=")
+				return nil, fmt.Errorf("instance %s and %s have the same %s: %s", other, c.Name, k, v)
+			}
+			seen[key] = c.Name
+		}
+		configs = append(configs, c)
+	}
+	return
+}
+
+// runInstances 为每个实例启动一个独立挂载命名空间的 xlpdok 子进程，所有实例共享同一份已解压的 SPK
+func runInstances(ctx context.Context, cfg Config) sys.Runner {
+	return func() (err error) {
+		var configs []Config
+		if configs, err = instanceConfigs(cfg); err != nil {
+			return
+		}
+
+		var w sync.WaitGroup
+		for _, c := range configs {
+			if err = sys.Exec(
+				checkMount(c),
+				sys.Mkdir(c.DirData, fo.RChmod(0777), fo.RChown(c.Uid, c.Gid)),
+				sys.Mkdirs(c.DirDownload, fo.Chmod(0777)),
+			); err != nil {
+				return
+			}
+			w.Go(func() { superviseInstance(ctx, c) })
+		}
+		w.Wait()
+		return
+	}
+}
+
+// superviseInstance 运行实例子进程，异常退出后按退避间隔重启，直到 ctx 结束
+func superviseInstance(ctx context.Context, c Config) {
+	data, err := json.Marshal(c)
+	if err != nil {
+		slog.ErrorContext(ctx, "instance config", "instance", c.Name, "err", err)
+		return
+	}
+
+	backoff := instanceBackoffMin
+	for {
+		start := time.Now()
+		err = runInstance(ctx, data)
+		if ctx.Err() != nil {
+			slog.InfoContext(ctx, "instance exited!", "instance", c.Name)
+			return
+		}
+
+		var ee *exec.ExitError
+		if errors.As(err, &ee) && ee.ExitCode() == exitMountLost {
+			slog.ErrorContext(ctx, "instance stopped, mount lost", "instance", c.Name)
+			return
+		}
+
+		if time.Since(start) > instanceBackoffMax {
+			backoff = instanceBackoffMin
+		}
+		slog.ErrorContext(ctx, "instance exited, restarting", "instance", c.Name, "err", err, "after", backoff)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, instanceBackoffMax)
+	}
+}
+
+// runInstance 启动一次实例子进程并等待退出，配置通过管道(子进程的 fd 3)传入
+func runInstance(ctx context.Context, config []byte) (err error) {
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		return
+	}
+	defer pw.Close()
+
+	cmd := exec.CommandContext(ctx, "/proc/self/exe")
+	cmd.Env = sys.Environ().Set(ENV_INSTANCE_CONFIG, "3")
+	cmd.ExtraFiles = []*os.File{pr}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Cloneflags: syscall.CLONE_NEWNS | syscall.CLONE_NEWPID | syscall.CLONE_NEWUTS}
+	cmd.Cancel = func() error { return cmd.Process.Signal(syscall.SIGTERM) }
+	cmd.WaitDelay = instanceStopTimeout
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	err = cmd.Start()
+	pr.Close()
+	if err != nil {
+		return
+	}
+
+	// 配置可能超过管道缓冲，边写边由子进程读取；子进程提前退出时写入失败，由 Wait 的结果说明原因
+	go func() { pw.Write(config); pw.Close() }()
+	return cmd.Wait()
+}
+
+// instanceFromEnv 读取父进程通过管道传入的实例配置
+func instanceFromEnv() (cfg Config, ok bool, err error) {
+	fd := os.Getenv(ENV_INSTANCE_CONFIG)
+	if fd == "" {
+		return
+	}
+	os.Unsetenv(ENV_INSTANCE_CONFIG)
+
+	n, err := strconv.Atoi(fd)
+	if err != nil {
+		return cfg, true, fmt.Errorf("%s is invalid: %q", ENV_INSTANCE_CONFIG, fd)
+	}
+	f := os.NewFile(uintptr(n), "instance-config")
+	defer f.Close()
+	err = json.NewDecoder(f).Decode(&cfg)
+	return cfg, true, err
+}
+
+// RunInstance 在实例子进程中运行，此时已处于独立的挂载命名空间，
+// 将实例私有的 var 目录绑定到 DIR_VAR 上后启动迅雷
+func RunInstance(ctx context.Context, cfg Config) (err error) {
+	hooks := &hook.Hooks{Uid: cfg.Uid, Gid: cfg.Gid, Env: mockEnv(cfg.DirData, downloadPATH(cfg)).Set("XL_INSTANCE", cfg.Name), List: cfg.Hooks}
+	defer hooks.Fire(ctx, hook.Shutdown)
+
+	varDir := filepath.Join(DIR_INSTANCES, cfg.Name, "var")
+	return sys.Exec(
+		sys.Mount("", "/", "", syscall.MS_PRIVATE|syscall.MS_REC, ""),
+		sys.Mkdir("/proc", fo.Chmod(0755)),
+		sys.Mount("none", "/proc", "proc", syscall.MS_NOSUID|syscall.MS_NOEXEC|syscall.MS_NODEV, ""),
+		sys.Mkdir(varDir, fo.Chmod(0777, true), fo.Chown(cfg.Uid, cfg.Gid, true)),
+		sys.Mkdir(DIR_VAR, fo.Chmod(0777)),
+		sys.Mount(varDir, DIR_VAR, "", syscall.MS_BIND, ""),
+		bindDownloads(cfg),
+		hooks.Runner(ctx, hook.PreStart),
+		launch(ctx, cfg, hooks),
+	)
+}
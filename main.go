@@ -5,7 +5,10 @@ import (
 	"context"
 	"crypto/rand"
 	"encoding/base32"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io/fs"
 	"log/slog"
 	"net"
 	"net/http"
@@ -15,15 +18,31 @@ import (
 	"os/signal"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
+	"time"
 
 	"xlpdok/pkg/arrx"
+	"xlpdok/pkg/cleanup"
+	"xlpdok/pkg/disk"
 	"xlpdok/pkg/embed"
 	"xlpdok/pkg/fo"
+	"xlpdok/pkg/hook"
+	"xlpdok/pkg/httpx"
+	"xlpdok/pkg/metrics"
+	"xlpdok/pkg/mount"
+	"xlpdok/pkg/notify"
+	"xlpdok/pkg/perm"
+	"xlpdok/pkg/rule"
 	"xlpdok/pkg/spk"
+	"xlpdok/pkg/status"
 	"xlpdok/pkg/sys"
+	"xlpdok/pkg/timex"
+	"xlpdok/pkg/unpack"
+	"xlpdok/pkg/webhook"
 
 	"github.com/cnk3x/flags"
 	"github.com/go-chi/chi/v5"
@@ -42,6 +61,10 @@ const (
 	FILE_PAN_XUNLEI_CLI = "/var/packages/pan-xunlei-com/target/bin/bin/xunlei-pan-cli-launcher." + runtime.GOARCH // 启动器
 	FILE_INDEX_CGI      = "/var/packages/pan-xunlei-com/target/ui/index.cgi"                                      // CGI文件路径
 	DIR_VAR             = "/var/packages/pan-xunlei-com/target/var"                                               // SYNOPKG_PKGROOT
+	DIR_SPK_CACHE       = "/var/packages/pan-xunlei-com/cache"                                                    // SPK 缓存目录
+	DIR_SPK_VERSIONS    = "/var/packages/pan-xunlei-com/versions"                                                 // 各版本的解压目录，target 为指向当前版本的链接
+	DIR_SHARED_VAR      = "/var/packages/pan-xunlei-com/var"                                                      // 各版本共享的 var 目录
+	DIR_NAMED_DOWNLOADS = "/downloads"                                                                            // 命名下载目录的挂载位置
 	// FILE_PID            = "/var/packages/pan-xunlei-com/target/var/pan-xunlei-com.pid"                            // 进程文件
 	// FILE_SOCK_LAUNCHER  = "/var/packages/pan-xunlei-com/target/var/pan-xunlei-com-launcher.sock"                  // 启动器监听地址
 	// FILE_SOCK_DRIVE     = "/var/packages/pan-xunlei-com/target/var/pan-xunlei-com.sock"                           // 主程序监听地址
@@ -58,21 +81,82 @@ const (
 )
 
 type Config struct {
-	Listen        string   `flag:"" short:"l" usage:"面板监听地址" env:"XL_LISTEN" json:"listen,omitempty"`
-	DirDownload   []string `flag:"" short:"d" usage:"下载保存路径，多个路径以冒号:隔开" env:"XL_DIR_DOWNLOAD" json:"dir_download,omitempty"`
-	DirData       string   `flag:"" short:"c" usage:"账号保存路径" env:"XL_DIR_DATA" json:"dir_data,omitempty"`
-	Uid           int      `flag:"" short:"u" usage:"运行spk的UID" env:"XL_UID" json:"uid,omitempty"`
-	Gid           int      `flag:"" short:"g" usage:"运行spk的GID" env:"XL_GID" json:"gid,omitempty"`
-	PreventUpdate bool     `flag:"" usage:"禁止更新" env:"XL_PREVENT_UPDATE" json:"prevent_update,omitempty"`
-	Busybox       bool     `flag:"" usage:"使用内嵌Busybox文件系统" env:"XL_BUSYBOX" json:"busybox,omitempty"`
+	Listen         string        `flag:"" short:"l" usage:"面板监听地址" env:"XL_LISTEN" json:"listen,omitempty"`
+	DirDownload    []string      `flag:"" short:"d" usage:"下载保存路径，多个路径以冒号:隔开，可用 名称=路径 的形式挂载到 /downloads/名称" env:"XL_DIR_DOWNLOAD" json:"dir_download,omitempty"`
+	DirData        string        `flag:"" short:"c" usage:"账号保存路径" env:"XL_DIR_DATA" json:"dir_data,omitempty"`
+	Uid            int           `flag:"" short:"u" usage:"运行spk的UID" env:"XL_UID" json:"uid,omitempty"`
+	Gid            int           `flag:"" short:"g" usage:"运行spk的GID" env:"XL_GID" json:"gid,omitempty"`
+	PreventUpdate  bool          `flag:"" usage:"禁止更新" env:"XL_PREVENT_UPDATE" json:"prevent_update,omitempty"`
+	Busybox        bool          `flag:"" usage:"使用内嵌Busybox文件系统" env:"XL_BUSYBOX" json:"busybox,omitempty"`
+	Umask          string        `flag:"" usage:"迅雷进程的umask，八进制，如 002" env:"XL_UMASK" json:"umask,omitempty"`
+	RequireMount   bool          `flag:"" usage:"要求下载目录和账号目录是挂载点，否则拒绝启动" env:"XL_REQUIRE_MOUNT" json:"require_mount,omitempty"`
+	SpkUrl         []string      `flag:"" usage:"SPK下载地址(http、https、file、oci、dir、- 标准输入)，可指定多个镜像按顺序尝试" env:"XL_SPK_URL" json:"spk_url,omitempty"`
+	SpkRetries     int           `flag:"" usage:"每个SPK镜像的尝试次数" env:"XL_SPK_RETRIES" json:"spk_retries,omitempty"`
+	SpkTimeout     time.Duration `flag:"" usage:"每次SPK下载尝试的超时" env:"XL_SPK_TIMEOUT" json:"spk_timeout,omitempty"`
+	SpkSha256      string        `flag:"" usage:"SPK文件的SHA-256，不匹配时拒绝安装" env:"XL_SPK_SHA256" json:"spk_sha256,omitempty"`
+	SpkManifest    string        `flag:"" usage:"SPK签名清单地址(sha256sum格式，签名位于地址+.sig)" env:"XL_SPK_MANIFEST" json:"spk_manifest,omitempty"`
+	SpkManifestKey string        `flag:"" usage:"SPK签名清单的ed25519公钥(base64)" env:"XL_SPK_MANIFEST_KEY" json:"spk_manifest_key,omitempty"`
+	SpkCache       string        `flag:"" usage:"SPK缓存目录，设为 none 不缓存" env:"XL_SPK_CACHE" json:"spk_cache,omitempty"`
+	SpkFull        bool          `flag:"" usage:"提取SPK中的全部文件，而不只是运行必需的文件" env:"XL_SPK_FULL" json:"spk_full,omitempty"`
+	SpkInclude     []string      `flag:"" usage:"额外提取的SPK文件(glob，目录/**匹配整个目录)" env:"XL_SPK_INCLUDE" json:"spk_include,omitempty"`
+	SpkExclude     []string      `flag:"" usage:"不提取的SPK文件(glob)，运行必需的文件除外" env:"XL_SPK_EXCLUDE" json:"spk_exclude,omitempty"`
+	SpkCheck       string        `flag:"" usage:"启动时检查SPK文件完整性: fast(大小和修改时间)、deep(重新计算SHA-256)、none" env:"XL_SPK_CHECK" json:"spk_check,omitempty"`
+	SpkKeyring     []string      `flag:"" usage:"校验群晖SPK签名(syno_signature.asc)的公钥环文件，为空时使用内置的群晖公钥" env:"XL_SPK_KEYRING" json:"spk_keyring,omitempty"`
+	SpkStrict      bool          `flag:"" usage:"拒绝没有签名或签名无效的SPK，需要公钥环" env:"XL_SPK_STRICT" json:"spk_strict,omitempty"`
+	SpkPin         string        `flag:"" usage:"固定使用的SPK版本，未安装时从SPK地址下载且版本必须一致" env:"XL_SPK_PIN" json:"spk_pin,omitempty"`
+	SpkKeep        int           `flag:"" usage:"保留的SPK版本数，0不清理" env:"XL_SPK_KEEP" json:"spk_keep,omitempty"`
+	Proxy          string        `flag:"" usage:"出站请求代理(http、https、socks5，可带用户名密码)，为空使用 HTTP_PROXY 等环境变量" env:"XL_PROXY" json:"proxy,omitempty"`
+	TlsInsecure    bool          `flag:"" usage:"出站请求跳过证书校验" env:"XL_TLS_INSECURE" json:"tls_insecure,omitempty"`
+	TlsCa          string        `flag:"" usage:"出站请求额外信任的CA证书文件(PEM)" env:"XL_TLS_CA" json:"tls_ca,omitempty"`
+	TlsCert        string        `flag:"" usage:"出站请求的客户端证书文件(PEM)" env:"XL_TLS_CERT" json:"tls_cert,omitempty"`
+	TlsKey         string        `flag:"" usage:"出站请求的客户端私钥文件(PEM)" env:"XL_TLS_KEY" json:"tls_key,omitempty"`
+	UpdateCheck    time.Duration `flag:"" usage:"检查上游新版本的间隔，如 24h，0(默认)不检查" env:"XL_UPDATE_CHECK" json:"update_check,omitempty"`
+	Output         string        `flag:"" short:"o" usage:"子命令的输出格式(text、json)" json:"-"`
+	ConfigFile     string        `flag:"config" usage:"JSON配置文件路径，文件中的值优先于命令行" env:"XL_CONFIG" json:"-"`
+
+	Hooks     []hook.Hook `json:"hooks,omitempty"`     // 生命周期钩子，仅支持配置文件
+	Instances []Instance  `json:"instances,omitempty"` // 多账号实例，仅支持配置文件
+	Name      string      `json:"name,omitempty"`      // 实例名称，由多实例模式填充
+
+	DownloadNames map[string]string `json:"download_names,omitempty"` // 下载目录 -> 名称，由 DirDownload 中 名称=路径 的形式解析
+
+	Webhooks     []webhook.Webhook `json:"webhooks,omitempty"`      // 下载完成回调
+	TempSuffixes []string          `json:"temp_suffixes,omitempty"` // 下载中临时文件后缀，默认 watch.DefaultTempSuffixes
+	Notifiers    notify.Hub        `json:"notifiers,omitempty"`     // 推送通知渠道
+	Rules        rule.Rules        `json:"rules,omitempty"`         // 下载完成后的移动、重命名、硬链接规则
+	Ownership    *perm.Policy      `json:"ownership,omitempty"`     // 下载目录中新文件的属主和权限
+	DiskGuard    *disk.Guard       `json:"disk_guard,omitempty"`    // 下载目录剩余空间守护
+	Mount        *mount.Require    `json:"mount,omitempty"`         // 下载目录和账号目录的挂载要求
+	Cleanup      *cleanup.Cleaner  `json:"cleanup,omitempty"`       // 下载目录的自动清理规则
+	Unpack       *unpack.Config    `json:"unpack,omitempty"`        // 下载完成后自动解压
+}
+
+// UnmarshalJSON 配置文件中的时长可写成 "30s" 形式的字符串，也可写成纳秒数字。
+// 命令行参数只支持 time.Duration，这些字段不能直接声明为 timex.Duration
+func (c *Config) UnmarshalJSON(data []byte) error {
+	type plain Config
+	return json.Unmarshal(data, &struct {
+		*plain
+		SpkTimeout  *timex.Duration `json:"spk_timeout,omitempty"`
+		UpdateCheck *timex.Duration `json:"update_check,omitempty"`
+	}{
+		plain:       (*plain)(c),
+		SpkTimeout:  (*timex.Duration)(&c.SpkTimeout),
+		UpdateCheck: (*timex.Duration)(&c.UpdateCheck),
+	})
 }
 
 var BuildTime string
 var Version = "0.1.0-beta"
 
 func main() {
-	var cfg Config
-	fSet := flags.NewSet(flags.SetVersion(Version), flags.SetBuildTime(BuildTime), flags.SetDescription("xunlei wrap"))
+	if cfg, ok, err := instanceFromEnv(); ok {
+		mainInstance(cfg, err)
+		return
+	}
+
+	cfg := Config{SpkKeep: 3}
+	fSet := flags.NewSet(flags.SetVersion(Version), flags.SetBuildTime(BuildTime), flags.SetDescription("xunlei wrap\n\nCOMMANDS:\n  (默认)        启动迅雷\n  check-update  检查上游新版本\n  upgrade       下载并安装上游新版本\n  rollback      切换回上一个SPK版本\n  inspect FILE  查看SPK文件或地址的内容\n  install SRC   从指定来源安装SPK，- 为标准输入"))
 	fSet.Struct(&cfg)
 	fSet.Parse()
 
@@ -88,8 +172,25 @@ func main() {
 		return
 	}
 
+	if err := setupHTTP(cfg); err != nil {
+		slog.ErrorContext(ctx, "app exited!", "err", err)
+		return
+	}
+
+	if cmd := fSet.Arg(0); cmd != "" {
+		if err := runCommand(ctx, cfg, cmd, fSet.Args()[1:]); err != nil {
+			slog.ErrorContext(ctx, cmd+" fail", "err", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	if err := Run(ctx, cfg); err != nil {
 		slog.ErrorContext(ctx, "app exited!", "err", err)
+		if errors.Is(err, errMountLost) || errors.Is(err, errXunleiExited) {
+			cancel()
+			os.Exit(1)
+		}
 	} else {
 		slog.InfoContext(ctx, "app exited!")
 	}
@@ -97,18 +198,111 @@ func main() {
 	<-ctx.Done()
 }
 
+func runCommand(ctx context.Context, cfg Config, cmd string, args []string) error {
+	switch cmd {
+	case "check-update":
+		return cmdCheckUpdate(ctx, cfg)
+	case "upgrade":
+		return cmdUpgrade(ctx, cfg)
+	case "rollback":
+		return cmdRollback(ctx, cfg)
+	case "inspect":
+		return cmdInspect(ctx, cfg, args)
+	case "install":
+		return cmdInstall(ctx, cfg, args)
+	case "spk":
+		// spk install、spk inspect 等同于 install、inspect
+		if len(args) > 0 && (args[0] == "install" || args[0] == "inspect") {
+			return runCommand(ctx, cfg, args[0], args[1:])
+		}
+		return errors.New("usage: xlpdok spk install|inspect SOURCE")
+	default:
+		return fmt.Errorf("unknown command: %s", cmd)
+	}
+}
+
+// mainInstance 多实例模式下子进程的入口
+func mainInstance(cfg Config, err error) {
+	slog.SetDefault(slog.New(tint.NewHandler(colorable.NewColorable(os.Stderr), &tint.Options{Level: slog.LevelDebug})).With("instance", cfg.Name))
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	if err == nil {
+		err = setupHTTP(cfg)
+	}
+	if err == nil {
+		err = RunInstance(ctx, cfg)
+	}
+
+	if err != nil {
+		slog.ErrorContext(ctx, "instance exited!", "err", err)
+		if errors.Is(err, errMountLost) {
+			os.Exit(exitMountLost)
+		}
+		os.Exit(1)
+	}
+	slog.InfoContext(ctx, "instance exited!")
+}
+
 func configCheck(cfg *Config) (err error) {
+	if cfg.ConfigFile != "" {
+		var data []byte
+		if data, err = os.ReadFile(cfg.ConfigFile); err != nil {
+			return
+		}
+		if err = json.Unmarshal(data, cfg); err != nil {
+			return fmt.Errorf("parse config %s: %w", cfg.ConfigFile, err)
+		}
+	}
+
+	if err = configNormalize(cfg); err != nil {
+		return
+	}
+
+	if len(cfg.Instances) > 0 {
+		_, err = instanceConfigs(*cfg)
+	}
+	return
+}
+
+func configNormalize(cfg *Config) (err error) {
 	var dirDownload []string
 	for _, d := range cfg.DirDownload {
 		for p := range strings.SplitSeq(d, ":") {
-			if p = strings.TrimSpace(p); p != "" {
-				if p, err = filepath.Abs(p); err != nil {
-					return
+			name, dir, named := strings.Cut(strings.TrimSpace(p), "=")
+			if !named {
+				dir = name
+			}
+			if dir = strings.TrimSpace(dir); dir == "" {
+				continue
+			}
+			if dir, err = filepath.Abs(dir); err != nil {
+				return
+			}
+			dirDownload = append(dirDownload, dir)
+
+			if named {
+				if name = strings.TrimSpace(name); name == "" || name == "." || name == ".." || strings.ContainsAny(name, "/=") {
+					return fmt.Errorf("download dir name is invalid: %q", name)
+				}
+				if cfg.DownloadNames == nil {
+					cfg.DownloadNames = map[string]string{}
 				}
-				dirDownload = append(dirDownload, p)
+				cfg.DownloadNames[dir] = name
 			}
 		}
 	}
+
+	seen := map[string]bool{}
+	for _, name := range cfg.DownloadNames {
+		if seen[name] {
+			return fmt.Errorf("download dir name is duplicated: %s", name)
+		}
+		seen[name] = true
+	}
 	cfg.DirDownload = dirDownload
 	if len(dirDownload) == 0 {
 		cfg.DirDownload = append(cfg.DirDownload, "/xunlei/downloads")
@@ -119,70 +313,354 @@ func configCheck(cfg *Config) (err error) {
 	}
 
 	cfg.Listen = cmp.Or(cfg.Listen, ":2345")
+	cfg.SpkCache = cmp.Or(cfg.SpkCache, DIR_SPK_CACHE)
+	if len(cfg.SpkUrl) == 0 {
+		cfg.SpkUrl = []string{spk.DownloadUrl}
+	}
+
+	if cfg.RequireMount && cfg.Mount == nil {
+		cfg.Mount = &mount.Require{Mountpoint: true}
+	}
+
+	switch cfg.SpkCheck = cmp.Or(cfg.SpkCheck, "fast"); cfg.SpkCheck {
+	case "fast", "deep", "none":
+	default:
+		return fmt.Errorf("invalid spk_check: %s", cfg.SpkCheck)
+	}
+
+	if cfg.SpkStrict && len(cfg.SpkKeyring) == 0 && len(spk.DefaultKeyring()) == 0 {
+		return errors.New("spk_strict needs spk_keyring, no keyring is embedded")
+	}
+
+	if cfg.Umask != "" {
+		_, err = fo.ParseMode(cfg.Umask)
+	}
 	return
 }
 
+// setupHTTP 应用出站请求的代理和 TLS 配置
+func setupHTTP(cfg Config) error {
+	return httpx.Setup(httpx.Config{Proxy: cfg.Proxy, Insecure: cfg.TlsInsecure, CAFile: cfg.TlsCa, CertFile: cfg.TlsCert, KeyFile: cfg.TlsKey})
+}
+
 func Run(ctx context.Context, cfg Config) (err error) {
 	if cfg.Busybox {
 		embed.ExtractEmbed("/")
 	}
 
+	hooks := &hook.Hooks{Uid: cfg.Uid, Gid: cfg.Gid, Env: mockEnv(cfg.DirData, downloadPATH(cfg)), List: cfg.Hooks}
+
 	confContent := arrx.Stoa(`platform_name="`+SYNO_PLATFORM+`"`, `synobios="`+SYNO_PLATFORM+`"`, `unique="synology_`+SYNO_PLATFORM+`_`+SYNO_MODEL+`"`)
-	return sys.Exec(
+	prepare := sys.Steps(
 		sys.Mkfile(FILE_SYNO_INFO_CONF, confContent, false),
 		sys.Mkfile(FILE_SYNO_AUTHENTICATE_CGI, arrx.Stoa(embed.AuthenticateGgi), false, fo.Chmod(0777)),
 		sys.Rmfile("/.dockerenv"),
+	)
+
+	// 多实例模式：共享的 SPK 在父进程中准备，其余交给各实例子进程
+	if len(cfg.Instances) > 0 {
+		return sys.Exec(
+			prepare,
+			downloadSpk(ctx, cfg, hooks),
+			sys.Chown(DIR_SYNOPKG_PKGDEST+"/", cfg.Uid, cfg.Gid, true),
+			runInstances(ctx, cfg),
+		)
+	}
+
+	defer hooks.Fire(ctx, hook.Shutdown)
+	return sys.Exec(
+		prepare,
+		checkMount(cfg),
 		sys.Mkdir(cfg.DirData, fo.RChmod(0777), fo.RChown(cfg.Uid, cfg.Gid)),
 		sys.Mkdirs(cfg.DirDownload, fo.Chmod(0777)),
-		sys.Unshare(syscall.CLONE_NEWNS|syscall.CLONE_NEWPID|syscall.CLONE_NEWUTS),
+		// 不在线程上 unshare CLONE_NEWPID：从该线程 fork 的第一个子进程(通常是 pre-start 钩子)会成为新 PID 命名空间的 init，
+		// 它退出后线程无法再创建子进程。迅雷通过 Cloneflags 获得自己的 PID 命名空间
+		sys.Unshare(syscall.CLONE_NEWNS|syscall.CLONE_NEWUTS),
 		// sys.Unshare(syscall.CLONE_NEWNS),
 		sys.Mount("", "/", "", syscall.MS_PRIVATE|syscall.MS_REC, ""),
 		sys.Mkdir("/proc", fo.Chmod(0755)),
 		sys.Mount("none", "/proc", "proc", syscall.MS_NOSUID|syscall.MS_NOEXEC|syscall.MS_NODEV, ""),
-		downloadSpk(ctx, spk.DownloadUrl),
-		sys.Chown(DIR_SYNOPKG_PKGDEST, cfg.Uid, cfg.Gid, true),
-		sys.Mkdir(DIR_VAR, fo.Chmod(0777, true), fo.Chown(cfg.Uid, cfg.Gid, true)),
-		launch(ctx, cfg),
+		bindDownloads(cfg),
+		downloadSpk(ctx, cfg, hooks),
+		sys.Chown(DIR_SYNOPKG_PKGDEST+"/", cfg.Uid, cfg.Gid, true),
+		sys.Mkdir(DIR_SHARED_VAR, fo.Chmod(0777, true), fo.Chown(cfg.Uid, cfg.Gid, true)),
+		hooks.Runner(ctx, hook.PreStart),
+		launch(ctx, cfg, hooks),
 	)
 }
 
-func launch(ctx context.Context, cfg Config) func() error {
+func launch(ctx context.Context, cfg Config, hooks *hook.Hooks) func() error {
 	return sys.RunAs(cfg.Uid, cfg.Gid, func() error {
-		ctx, cancel := context.WithCancel(ctx)
-		defer cancel()
+		ctx, cancel := context.WithCancelCause(ctx)
+		defer cancel(nil)
 
 		var w sync.WaitGroup
-		cmdEnv := mockEnv(cfg.DirData, strings.Join(cfg.DirDownload, ":"))
-		w.Go(func() {
-			cmd := exec.CommandContext(ctx, FILE_PAN_XUNLEI_CLI,
-				"-launcher_listen", "unix:///var/packages/pan-xunlei-com/target/var/pan-xunlei-com-launcher.sock",
-				"-pid", "/var/packages/pan-xunlei-com/target/var/pan-xunlei-com.pid",
-			)
-			if cfg.PreventUpdate {
-				cmd.Args = append(cmd.Args, "-update_url", "null")
+		var pid atomic.Int64
+		hold := make(chan bool)
+		cmdEnv := hooks.Env
+
+		w.Go(func() { mockWeb(ctx, cfg, cmdEnv, func() { cancel(nil) }) })
+		if len(cfg.Webhooks) > 0 || len(cfg.Notifiers) > 0 || len(cfg.Rules) > 0 || cfg.Unpack != nil {
+			w.Go(func() { watchDownloads(ctx, cfg) })
+		}
+		if cfg.DiskGuard != nil {
+			w.Go(func() { guardDisk(ctx, cfg, hold) })
+		}
+		if cfg.Mount != nil {
+			w.Go(func() { watchMount(ctx, cfg, &pid, cancel) })
+		}
+		if len(cfg.Notifiers) > 0 {
+			w.Go(func() { watchLogin(ctx, cfg, &pid) })
+		}
+		if cfg.UpdateCheck > 0 {
+			w.Go(func() { watchUpdate(ctx, cfg) })
+		}
+		if cfg.Cleanup != nil {
+			w.Go(func() {
+				c := *cfg.Cleanup
+				c.TaskExists = taskExists(cfg)
+				c.Run(ctx, cfg.DirDownload, tempSuffixes(cfg))
+			})
+		}
+		if cfg.Ownership != nil {
+			w.Go(func() {
+				if err := perm.Enforce(ctx, cfg.DirDownload, *cfg.Ownership); err != nil {
+					slog.WarnContext(ctx, "enforce ownership", "err", err)
+				}
+			})
+		}
+
+		// 迅雷退出后停止其他任务，由调用方以非零状态退出，多实例模式下由父进程重启实例
+		if err := runXunlei(ctx, cfg, hooks, &pid, hold); err != nil {
+			cancel(err)
+		}
+		w.Wait()
+
+		// 挂载丢失或迅雷退出时将原因返回给调用方
+		if err := context.Cause(ctx); errors.Is(err, errMountLost) || errors.Is(err, errXunleiExited) {
+			return err
+		}
+		return nil
+	})
+}
+
+// errXunleiExited 迅雷未被要求停止就退出了，或者无法启动
+var errXunleiExited = errors.New("xunlei exited")
+
+// runXunlei 启动迅雷并等待退出。启动、等待和钩子都在当前(锁定且已 unshare 的)线程上进行，
+// 迅雷和钩子才能继承私有挂载命名空间中的 /proc 和下载目录绑定；其他线程仍处于原始命名空间。
+// 从 hold 收到 true 时正常停止迅雷，收到 false 后重新启动。
+// ctx 结束导致的退出返回 nil，否则返回 errXunleiExited
+func runXunlei(ctx context.Context, cfg Config, hooks *hook.Hooks, pid *atomic.Int64, hold <-chan bool) error {
+	held := false
+	for {
+		for held {
+			select {
+			case <-ctx.Done():
+				return nil
+			case held = <-hold:
 			}
-			cmd.Dir = DIR_SYNOPKG_WORK
-			cmd.Env = cmdEnv
-			cmd.SysProcAttr = &syscall.SysProcAttr{Cloneflags: syscall.CLONE_NEWNS | syscall.CLONE_NEWPID | syscall.CLONE_NEWUTS, Setpgid: true}
-			cmd.Cancel = func() error { return syscall.Kill(-cmd.Process.Pid, syscall.SIGINT) }
-			cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
-			if err := cmd.Start(); err != nil {
-				slog.ErrorContext(ctx, "start", "cmdline", strings.Join(cmd.Args, " "), "err", err)
-				return
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		runCtx, stop := context.WithCancel(ctx)
+		cmd := xunleiCmd(runCtx, cfg, hooks.Env)
+		if err := startWithUmask(cmd, cfg.Umask); err != nil {
+			stop()
+			slog.ErrorContext(ctx, "start", "cmdline", strings.Join(cmd.Args, " "), "err", err)
+			return fmt.Errorf("%w: start: %w", errXunleiExited, err)
+		}
+		slog.InfoContext(ctx, "start", "cmdline", strings.Join(cmd.Args, " "))
+		pid.Store(int64(cmd.Process.Pid))
+		setXunleiStatus(cmd.Process.Pid, "running")
+		hooks.Fire(ctx, hook.PostStart, "XL_PID", strconv.Itoa(cmd.Process.Pid))
+
+		exited := make(chan error, 1)
+		go func() { exited <- cmd.Wait() }()
+
+		var err error
+		stopped := false
+		for done := false; !done; {
+			select {
+			case err = <-exited:
+				done = true
+			case held = <-hold:
+				if held && !stopped {
+					slog.InfoContext(ctx, "disk guard stopping xunlei", "pid", cmd.Process.Pid)
+					stop()
+					stopped = true
+				}
 			}
-			slog.InfoContext(ctx, "start", "cmdline", strings.Join(cmd.Args, " "))
+		}
+		stop()
 
-			if err := cmd.Wait(); err != nil && err != context.Canceled {
-				slog.ErrorContext(ctx, "cmd exited!", "err", err)
-			} else {
-				slog.InfoContext(ctx, "cmd exited!")
+		pid.Store(0)
+		if stopped {
+			setXunleiStatus(0, "paused")
+		} else {
+			setXunleiStatus(0, "exited")
+		}
+		if err != nil && err != context.Canceled {
+			slog.ErrorContext(ctx, "cmd exited!", "err", err)
+		} else {
+			slog.InfoContext(ctx, "cmd exited!")
+		}
+		hooks.Fire(ctx, hook.Exit, "XL_EXIT_CODE", hook.ExitCode(err))
+
+		switch {
+		case ctx.Err() != nil:
+			return nil
+		case stopped:
+			// 空间仍不足时等待恢复，否则立即重新启动
+			continue
+		case err != nil:
+			cfg.Notifiers.Notify(ctx, notify.Event{Name: notify.XunleiCrashed, Instance: cfg.Name, Data: map[string]any{"exit_code": hook.ExitCode(err), "err": err.Error()}})
+			return fmt.Errorf("%w: %w", errXunleiExited, err)
+		default:
+			return errXunleiExited
+		}
+	}
+}
+
+// xunleiCmd 迅雷启动器命令，ctx 结束时向进程组发送 SIGINT，仍未退出时由 WaitDelay 强制结束
+func xunleiCmd(ctx context.Context, cfg Config, env []string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, FILE_PAN_XUNLEI_CLI,
+		"-launcher_listen", "unix:///var/packages/pan-xunlei-com/target/var/pan-xunlei-com-launcher.sock",
+		"-pid", "/var/packages/pan-xunlei-com/target/var/pan-xunlei-com.pid",
+	)
+	if cfg.PreventUpdate {
+		cmd.Args = append(cmd.Args, "-update_url", "null")
+	}
+	cmd.Dir = DIR_SYNOPKG_WORK
+	cmd.Env = env
+	cmd.SysProcAttr = &syscall.SysProcAttr{Cloneflags: syscall.CLONE_NEWNS | syscall.CLONE_NEWPID | syscall.CLONE_NEWUTS, Setpgid: true}
+	cmd.Cancel = func() error { return syscall.Kill(-cmd.Process.Pid, syscall.SIGINT) }
+	cmd.WaitDelay = 30 * time.Second
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	return cmd
+}
+
+// downloadPATH 迅雷看到的下载路径，命名的目录显示为 /downloads/名称
+func downloadPATH(cfg Config) string {
+	paths := make([]string, 0, len(cfg.DirDownload))
+	for _, dir := range cfg.DirDownload {
+		if name, find := cfg.DownloadNames[dir]; find {
+			dir = filepath.Join(DIR_NAMED_DOWNLOADS, name)
+		}
+		paths = append(paths, dir)
+	}
+	return strings.Join(paths, ":")
+}
+
+// bindDownloads 在私有挂载命名空间中将命名的下载目录绑定到 /downloads/名称
+func bindDownloads(cfg Config) sys.Runner {
+	return func() (err error) {
+		for _, dir := range cfg.DirDownload {
+			if name, find := cfg.DownloadNames[dir]; find {
+				target := filepath.Join(DIR_NAMED_DOWNLOADS, name)
+				if err = sys.Exec(
+					sys.Mkdir(target, fo.Chmod(0777)),
+					sys.Mount(dir, target, "", syscall.MS_BIND|syscall.MS_REC, ""),
+				); err != nil {
+					return fmt.Errorf("bind download dir %s to %s: %w", dir, target, err)
+				}
+				slog.Debug("bind download dir", "dir", dir, "target", target)
 			}
-		})
+		}
+		return
+	}
+}
 
-		w.Go(func() { mockWeb(ctx, cfg, cmdEnv, cancel) })
-		w.Wait()
+// checkMount 在创建目录之前检查挂载，避免未挂载时数据写入容器层
+func checkMount(cfg Config) sys.Runner {
+	return func() error {
+		if cfg.Mount == nil {
+			return nil
+		}
+		return cfg.Mount.CheckAll(append([]string{cfg.DirData}, cfg.DirDownload...))
+	}
+}
+
+// errMountLost 运行中挂载检查失败且处理方式为 stop
+var errMountLost = errors.New("mount check failed")
+
+// watchMount 运行中定期在迅雷的挂载命名空间中检查挂载，失败时按配置处理
+func watchMount(ctx context.Context, cfg Config, pid *atomic.Int64, stop func(error)) {
+	cfg.Mount.Watch(ctx, append([]string{cfg.DirData}, cfg.DirDownload...), func() int { return int(pid.Load()) }, func(err error) {
+		action := cmp.Or(cfg.Mount.Action, mount.ActionStop)
+		if action != mount.ActionWarn {
+			cfg.Notifiers.Notify(ctx, notify.Event{Name: notify.MountFailed, Instance: cfg.Name, Data: map[string]any{"err": err.Error()}})
+		}
+		if action == mount.ActionStop {
+			slog.ErrorContext(ctx, "mount check fail, stopping xunlei")
+			stop(fmt.Errorf("%w: %w", errMountLost, err))
+		}
+	})
+}
+
+// 迅雷启动后等待这么久仍未登录才推送，留出首次扫码登录的时间
+const loginGrace = 5 * time.Minute
+
+// watchLogin 迅雷运行期间定期检查账号目录，没有登录信息时推送需要登录的通知，
+// 每次从已登录变为未登录时只推送一次
+func watchLogin(ctx context.Context, cfg Config, pid *atomic.Int64) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	notified := false
+	for start := time.Now(); ; {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if pid.Load() == 0 || time.Since(start) < loginGrace {
+			continue
+		}
+		if loggedIn(cfg.DirData) {
+			notified = false
+			continue
+		}
+		if !notified {
+			slog.WarnContext(ctx, "xunlei login required", "dir", cfg.DirData)
+			cfg.Notifiers.Notify(ctx, notify.Event{Name: notify.LoginRequired, Instance: cfg.Name, Data: map[string]any{"dir": cfg.DirData}})
+			notified = true
+		}
+	}
+}
+
+// loggedIn 迅雷登录后会把账号信息写入账号目录，目录中有任何文件即视为已登录
+func loggedIn(dir string) (find bool) {
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && d.Type().IsRegular() {
+			find = true
+			return filepath.SkipAll
+		}
 		return nil
 	})
+	return
+}
+
+// startWithUmask 以指定 umask 启动进程，子进程在 fork 时继承 umask。
+// umask 属于线程的 fs_struct，设置、fork 和恢复必须在同一线程上完成
+func startWithUmask(cmd *exec.Cmd, umask string) error {
+	if umask == "" {
+		return cmd.Start()
+	}
+
+	mask, err := fo.ParseMode(umask)
+	if err != nil {
+		return err
+	}
+
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	old := syscall.Umask(int(mask))
+	defer syscall.Umask(old)
+	return cmd.Start()
 }
 
 func mockWeb(ctx context.Context, cfg Config, env []string, onDone func()) (err error) {
@@ -196,6 +674,10 @@ func mockWeb(ctx context.Context, cfg Config, env []string, onDone func()) (err
 	mux.Get("/web", cgiRedir)
 	mux.Get("/webman", cgiRedir)
 
+	mux.Get("/xlpdok/status", status.Handler().ServeHTTP)
+	mux.Get("/xlpdok/events", status.Events().ServeHTTP)
+	mux.Get("/xlpdok/metrics", metrics.Handler().ServeHTTP)
+
 	mux.Mount(CGI_PATH, &cgi.Handler{
 		Dir:  DIR_SYNOPKG_WORK,
 		Path: FILE_INDEX_CGI,
@@ -235,7 +717,7 @@ func mockWeb(ctx context.Context, cfg Config, env []string, onDone func()) (err
 	return
 }
 
-func mockEnv(dirData, dirDownload string) []string {
+func mockEnv(dirData, dirDownload string) sys.EnvSet {
 	return sys.Environ().
 		Del("container", "KUBERNETES_SERVICE_HOST", "KUBERNETES_PORT", "DOCKER_IMAGE", "DOCKER_TAG").
 		Sets(
@@ -264,8 +746,137 @@ func randText(n int) (s string) {
 	return
 }
 
-func downloadSpk(ctx context.Context, spkUrl string) sys.Runner {
+func downloadSpk(ctx context.Context, cfg Config, hooks *hook.Hooks) sys.Runner {
 	return func() (err error) {
-		return spk.Download(ctx, spkUrl, DIR_SYNOPKG_PKGDEST, false)
+		oldVer := readVersion()
+		if err = installSpk(ctx, cfg); err != nil {
+			return
+		}
+		if newVer := readVersion(); newVer != oldVer {
+			hooks.Fire(ctx, hook.SpkUpdate, "XL_SPK_VERSION", newVer, "XL_SPK_OLD_VERSION", oldVer)
+			cfg.Notifiers.Notify(ctx, notify.Event{Name: notify.SpkUpdated, Data: map[string]any{"version": newVer, "old_version": oldVer}})
+		}
+		return
+	}
+}
+
+// spkStore 各 SPK 版本并存，DIR_SYNOPKG_PKGDEST 链接到当前版本
+func spkStore() spk.Store {
+	return spk.Store{Dir: DIR_SPK_VERSIONS, Link: DIR_SYNOPKG_PKGDEST, Shared: map[string]string{"var": DIR_SHARED_VAR}}
+}
+
+// installSpk 准备要使用的 SPK 版本：固定版本已安装时直接切换，当前版本完整时沿用，否则下载安装并切换
+func installSpk(ctx context.Context, cfg Config) (err error) {
+	store := spkStore()
+	if err = store.Migrate(ctx); err != nil {
+		return
+	}
+	defer store.GC(ctx, cfg.SpkKeep, cfg.SpkPin)
+
+	switch {
+	case cfg.SpkPin != "" && store.Has(cfg.SpkPin):
+		if err = store.Use(ctx, cfg.SpkPin); err != nil || checkSpk(ctx, cfg) {
+			return
+		}
+	case cfg.SpkPin == "" && spk.Installed(ctx, DIR_SYNOPKG_PKGDEST):
+		slog.InfoContext(ctx, "check spk all spk file exists", "version", store.Active())
+		if checkSpk(ctx, cfg) {
+			return
+		}
+	}
+
+	// 需要下载时先提供安装进度页面，迅雷启动前关闭
+	defer serveInstalling(ctx, cfg)()
+
+	version, err := store.Install(ctx, cfg.SpkUrl, spkOptions(cfg)...)
+	if err != nil {
+		setInstallStatus("failed", "", err)
+		return
+	}
+	if cfg.SpkPin != "" && version != cfg.SpkPin {
+		err = fmt.Errorf("spk pinned to %s, but spk url provides %s", cfg.SpkPin, version)
+		setInstallStatus("failed", version, err)
+		return
+	}
+	if err = store.Use(ctx, version); err != nil {
+		setInstallStatus("failed", version, err)
+		return
 	}
+	setInstallStatus("installed", version, nil)
+	return
+}
+
+// checkSpk 按完整性清单检查当前版本，损坏的文件优先从本地的 SPK 修复，返回 false 表示需要重新下载
+func checkSpk(ctx context.Context, cfg Config) bool {
+	if cfg.SpkCheck == "none" {
+		return true
+	}
+
+	bad, err := spk.Check(ctx, DIR_SYNOPKG_PKGDEST, cfg.SpkCheck == "deep")
+	switch {
+	case errors.Is(err, spk.ErrNoManifest):
+		slog.DebugContext(ctx, "spk check skipped, no manifest")
+		return true
+	case err != nil:
+		slog.WarnContext(ctx, "spk check fail", "err", err)
+		return false
+	case len(bad) == 0:
+		slog.InfoContext(ctx, "spk check ok", "mode", cfg.SpkCheck)
+		return true
+	}
+
+	for _, spkFile := range localSpkFiles(cfg) {
+		if err = spk.Repair(ctx, DIR_SYNOPKG_PKGDEST, spkFile, bad, spkFilter(cfg)); err == nil {
+			return true
+		}
+		slog.WarnContext(ctx, "spk repair fail", "spk", spkFile, "err", err)
+	}
+	return false
+}
+
+// localSpkFiles 本地可用的 SPK 文件：file 地址和已缓存的下载
+func localSpkFiles(cfg Config) (files []string) {
+	for _, spkUrl := range cfg.SpkUrl {
+		var file string
+		switch url := strings.ToLower(spkUrl); {
+		case strings.HasPrefix(url, "file://"):
+			file = strings.TrimPrefix(spkUrl, "file://")
+		case (strings.HasPrefix(url, "http://") || strings.HasPrefix(url, "https://")) && cfg.SpkCache != "none":
+			file = spk.CachedFile(cfg.SpkCache, spkUrl)
+		default:
+			continue
+		}
+		if _, err := os.Stat(file); err == nil {
+			files = append(files, file)
+		}
+	}
+	return
+}
+
+func setXunleiStatus(pid int, state string) {
+	status.Set("xunlei", map[string]any{"pid": pid, "state": state, "version": readVersion()})
+}
+
+func spkOptions(cfg Config) []spk.Option {
+	options := []spk.Option{
+		spk.WithSha256(cfg.SpkSha256),
+		spk.WithManifest(cfg.SpkManifest, cfg.SpkManifestKey),
+		spk.WithRetry(cfg.SpkRetries, cfg.SpkTimeout),
+		spk.WithFilter(spkFilter(cfg)),
+		spk.WithSignature(cfg.SpkKeyring, cfg.SpkStrict),
+		spk.WithProgress(spkProgress()...),
+	}
+	if cfg.SpkCache != "none" {
+		options = append(options, spk.WithCacheDir(cfg.SpkCache))
+	}
+	return options
+}
+
+func spkFilter(cfg Config) spk.Filter {
+	return spk.Filter{Full: cfg.SpkFull, Include: cfg.SpkInclude, Exclude: cfg.SpkExclude}
+}
+
+func readVersion() string {
+	v, _ := os.ReadFile(FILE_PAN_XUNLEI_VER)
+	return strings.TrimSpace(string(v))
 }
@@ -0,0 +1,317 @@
+package cleanup
+
+import (
+	"context"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"xlpdok/pkg/disk"
+	"xlpdok/pkg/timex"
+	"xlpdok/pkg/units"
+)
+
+const day = 24 * time.Hour
+
+// trashTimeLayout 回收项名称前缀中移入时间的格式
+const trashTimeLayout = "20060102150405"
+
+// 清理原因
+const (
+	ReasonAge       = "age"       // 超过保留天数
+	ReasonSpace     = "space"     // 剩余空间不足，淘汰最旧的
+	ReasonAbandoned = "abandoned" // 长时间未更新的临时文件
+)
+
+// Policy 下载目录的保留规则，下载目录下的每个顶层文件或文件夹视为一项
+type Policy struct {
+	Path          string      `json:"path,omitempty"`           // 为空时作用于所有下载目录
+	MaxAgeDays    int         `json:"max_age_days,omitempty"`   // 修改时间超过N天的项被清理，设置了 Trash 时移入回收目录超过N天的项被彻底删除
+	MinFree       units.Bytes `json:"min_free,omitempty"`       // 剩余空间低于该值时从最旧的项开始清理，设置了 Trash 时改为清空最旧的回收项
+	AbandonedDays int         `json:"abandoned_days,omitempty"` // 超过N天未更新且任务已不存在的临时文件视为已放弃的任务
+	Trash         string      `json:"trash,omitempty"`          // 移动到该目录而不是删除，需与下载目录在同一文件系统
+	DryRun        bool        `json:"dry_run,omitempty"`        // 只记录日志和审计，不执行
+}
+
+// Cleaner 定期按规则清理下载目录
+type Cleaner struct {
+	Interval timex.Duration `json:"interval,omitempty"`  // 检查间隔，默认1小时
+	AuditLog string         `json:"audit_log,omitempty"` // 审计日志文件(JSON Lines)，为空只写入程序日志
+	Policies []Policy       `json:"policies,omitempty"`
+
+	// TaskExists 临时文件对应的下载任务是否仍存在，由调用方提供。
+	// 仍存在的任务即使长时间未更新(如已暂停)也不视为已放弃；为空时只按修改时间判断
+	TaskExists func(path string) bool `json:"-"`
+}
+
+type item struct {
+	path    string
+	size    int64
+	modTime time.Time
+	active  bool      // 包含临时文件，仍在下载中
+	trashed time.Time // 移入回收目录的时间
+}
+
+// Run 定期清理 dirs，tempSuffixes 为下载中临时文件的后缀
+func (c Cleaner) Run(ctx context.Context, dirs []string, tempSuffixes []string) {
+	var audit *slog.Logger
+	if c.AuditLog != "" {
+		f, err := os.OpenFile(c.AuditLog, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+		if err != nil {
+			slog.ErrorContext(ctx, "open cleanup audit log", "path", c.AuditLog, "err", err)
+			return
+		}
+		defer f.Close()
+		audit = slog.New(slog.NewJSONHandler(f, nil))
+	}
+
+	ticker := time.NewTicker(c.Interval.Or(time.Hour))
+	defer ticker.Stop()
+	for {
+		for _, dir := range dirs {
+			if p, find := c.policy(dir); find {
+				p.run(ctx, audit, dir, tempSuffixes, c.TaskExists)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// policy 优先使用路径完全匹配的规则，其次使用未指定路径的规则
+func (c Cleaner) policy(dir string) (p Policy, find bool) {
+	for _, item := range c.Policies {
+		if item.Path == dir {
+			return item, true
+		}
+		if item.Path == "" && !find {
+			p, find = item, true
+		}
+	}
+	return
+}
+
+func (p Policy) run(ctx context.Context, audit *slog.Logger, dir string, tempSuffixes []string, taskExists func(string) bool) {
+	isTemp := func(name string) bool {
+		return slices.ContainsFunc(tempSuffixes, func(suffix string) bool { return strings.HasSuffix(name, suffix) })
+	}
+
+	if p.AbandonedDays > 0 {
+		deadline := time.Now().Add(-time.Duration(p.AbandonedDays) * day)
+		filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() || !isTemp(d.Name()) || p.inTrash(path) {
+				return nil
+			}
+			if fi, e := d.Info(); e == nil && fi.ModTime().Before(deadline) {
+				if taskExists != nil && taskExists(path) {
+					slog.DebugContext(ctx, "cleanup skip, task still exists", "path", path)
+					return nil
+				}
+				p.remove(ctx, audit, item{path: path, size: fi.Size(), modTime: fi.ModTime()}, ReasonAbandoned)
+			}
+			return nil
+		})
+	}
+
+	items, err := p.items(dir, isTemp)
+	if err != nil {
+		slog.WarnContext(ctx, "cleanup list", "dir", dir, "err", err)
+		return
+	}
+
+	if p.MaxAgeDays > 0 {
+		deadline := time.Now().Add(-time.Duration(p.MaxAgeDays) * day)
+		items = slices.DeleteFunc(items, func(it item) bool {
+			if !it.active && it.modTime.Before(deadline) {
+				p.remove(ctx, audit, it, ReasonAge)
+				return true
+			}
+			return false
+		})
+		p.expireTrash(ctx, audit, deadline)
+	}
+
+	if p.MinFree > 0 {
+		u, err := disk.Stat(dir)
+		if err != nil {
+			slog.WarnContext(ctx, "cleanup stat", "dir", dir, "err", err)
+			return
+		}
+
+		// 回收目录与下载目录在同一文件系统，移入回收目录不释放空间，改为从最旧的回收项开始彻底删除。
+		// dry-run 时按大小模拟释放
+		need := int64(p.MinFree) - u.Free
+		if p.Trash != "" {
+			need = p.purgeTrash(ctx, audit, need)
+		} else {
+			for _, it := range items {
+				if need <= 0 {
+					break
+				}
+				if !it.active && p.remove(ctx, audit, it, ReasonSpace) {
+					need -= it.size
+				}
+			}
+		}
+		if need > 0 {
+			slog.WarnContext(ctx, "cleanup cannot free enough space", "dir", dir, "need", units.HumanBytes(need))
+		}
+	}
+}
+
+// items 列出下载目录下的顶层项，按修改时间从旧到新排序
+func (p Policy) items(dir string, isTemp func(string) bool) (items []item, err error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		if strings.HasPrefix(entry.Name(), ".") || p.inTrash(path) {
+			continue
+		}
+
+		fi, e := entry.Info()
+		if e != nil {
+			continue
+		}
+
+		it := item{path: path, modTime: fi.ModTime()}
+		filepath.WalkDir(path, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return nil
+			}
+			if isTemp(d.Name()) {
+				it.active = true
+			}
+			if info, e := d.Info(); e == nil && !d.IsDir() {
+				it.size += info.Size()
+			}
+			return nil
+		})
+		items = append(items, it)
+	}
+
+	slices.SortFunc(items, func(a, b item) int { return a.modTime.Compare(b.modTime) })
+	return
+}
+
+// purgeTrash 从最旧的回收项开始彻底删除，直到释放 need 字节，返回仍需释放的字节数
+func (p Policy) purgeTrash(ctx context.Context, audit *slog.Logger, need int64) int64 {
+	if need <= 0 {
+		return need
+	}
+
+	purge := p
+	purge.Trash = ""
+	for _, it := range p.trashItems(ctx) {
+		if need <= 0 {
+			break
+		}
+		if purge.remove(ctx, audit, it, ReasonSpace) {
+			need -= it.size
+		}
+	}
+	return need
+}
+
+// expireTrash 彻底删除在 deadline 之前移入回收目录的项
+func (p Policy) expireTrash(ctx context.Context, audit *slog.Logger, deadline time.Time) {
+	purge := p
+	purge.Trash = ""
+	for _, it := range p.trashItems(ctx) {
+		if !it.trashed.Before(deadline) {
+			break
+		}
+		purge.remove(ctx, audit, it, ReasonAge)
+	}
+}
+
+// trashItems 列出回收项，按移入时间从旧到新排序。回收项以移入时间开头命名，无法解析时使用修改时间
+func (p Policy) trashItems(ctx context.Context) (items []item) {
+	if p.Trash == "" {
+		return
+	}
+
+	entries, err := os.ReadDir(p.Trash)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			slog.WarnContext(ctx, "cleanup list trash", "dir", p.Trash, "err", err)
+		}
+		return
+	}
+
+	for _, entry := range entries {
+		it := item{path: filepath.Join(p.Trash, entry.Name())}
+		if fi, e := entry.Info(); e == nil {
+			it.modTime = fi.ModTime()
+		}
+		it.trashed = it.modTime
+		if stamp, _, find := strings.Cut(entry.Name(), "-"); find {
+			if t, e := time.ParseInLocation(trashTimeLayout, stamp, time.Local); e == nil {
+				it.trashed = t
+			}
+		}
+		filepath.WalkDir(it.path, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return nil
+			}
+			if info, e := d.Info(); e == nil && !d.IsDir() {
+				it.size += info.Size()
+			}
+			return nil
+		})
+		items = append(items, it)
+	}
+
+	slices.SortFunc(items, func(a, b item) int { return a.trashed.Compare(b.trashed) })
+	return
+}
+
+func (p Policy) inTrash(path string) bool {
+	return p.Trash != "" && (path == p.Trash || strings.HasPrefix(path, p.Trash+"/"))
+}
+
+// remove 删除或移入回收目录，所有操作(包括 dry-run)都写入审计日志
+func (p Policy) remove(ctx context.Context, audit *slog.Logger, it item, reason string) (ok bool) {
+	action, target := "delete", ""
+	if p.Trash != "" {
+		action = "trash"
+		target = filepath.Join(p.Trash, time.Now().Format(trashTimeLayout)+"-"+filepath.Base(it.path))
+	}
+
+	var err error
+	if !p.DryRun {
+		if p.Trash != "" {
+			if err = os.MkdirAll(p.Trash, 0o777); err == nil {
+				err = os.Rename(it.path, target)
+			}
+		} else {
+			err = os.RemoveAll(it.path)
+		}
+	}
+
+	attrs := []any{"action", action, "reason", reason, "path", it.path, "size", it.size, "mod_time", it.modTime, "dry_run", p.DryRun}
+	if target != "" {
+		attrs = append(attrs, "target", target)
+	}
+	if err != nil {
+		attrs = append(attrs, "err", err.Error())
+		slog.WarnContext(ctx, "cleanup", attrs...)
+	} else {
+		slog.InfoContext(ctx, "cleanup", attrs...)
+	}
+	if audit != nil {
+		audit.InfoContext(ctx, "cleanup", attrs...)
+	}
+	return err == nil
+}
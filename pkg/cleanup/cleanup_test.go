@@ -0,0 +1,49 @@
+package cleanup
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestTrashMaxAge(t *testing.T) {
+	root := t.TempDir()
+	dir, trash := filepath.Join(root, "downloads"), filepath.Join(root, "trash")
+	old := time.Now().Add(-10 * day)
+	for _, p := range []string{
+		filepath.Join(dir, "old.mkv"),
+		filepath.Join(trash, old.Format(trashTimeLayout)+"-expired.mkv"),
+		filepath.Join(trash, time.Now().Add(-time.Hour).Format(trashTimeLayout)+"-recent.mkv"),
+	} {
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte("x"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		// 修改时间都很旧，回收项按移入时间过期
+		if err := os.Chtimes(p, old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	p := Policy{MaxAgeDays: 7, Trash: trash}
+	p.run(context.Background(), nil, dir, nil, nil)
+
+	entries, err := os.ReadDir(trash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name()[len(trashTimeLayout)+1:])
+	}
+	// old.mkv 刚移入回收目录，expired.mkv 已过期删除
+	slices.Sort(names)
+	if want := []string{"old.mkv", "recent.mkv"}; !slices.Equal(names, want) {
+		t.Fatalf("trash = %v, want %v", names, want)
+	}
+}
@@ -0,0 +1,19 @@
+package disk
+
+import "syscall"
+
+// Usage 文件系统空间
+type Usage struct {
+	Path  string `json:"path"`
+	Total int64  `json:"total"`
+	Free  int64  `json:"free"` // 非特权用户可用
+}
+
+// Stat 获取 path 所在文件系统的空间
+func Stat(path string) (u Usage, err error) {
+	var st syscall.Statfs_t
+	if err = syscall.Statfs(path, &st); err != nil {
+		return
+	}
+	return Usage{Path: path, Total: int64(st.Blocks) * st.Bsize, Free: int64(st.Bavail) * st.Bsize}, nil
+}
@@ -0,0 +1,118 @@
+package disk
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"xlpdok/pkg/metrics"
+	"xlpdok/pkg/status"
+	"xlpdok/pkg/timex"
+	"xlpdok/pkg/units"
+)
+
+// Level 空间不足的级别
+type Level int
+
+const (
+	OK     Level = iota // 空间充足
+	Warn                // 记录警告日志
+	Notify              // 推送通知
+	Pause               // 停止迅雷进程，空间恢复后重新启动
+)
+
+func (l Level) String() string {
+	return [...]string{"ok", "warn", "notify", "pause"}[l]
+}
+
+// Threshold 目录的剩余空间阈值，低于阈值进入对应级别，0表示不检查该级别
+type Threshold struct {
+	Path   string      `json:"path,omitempty"` // 为空时作用于所有下载目录
+	Warn   units.Bytes `json:"warn,omitempty"`
+	Notify units.Bytes `json:"notify,omitempty"`
+	Pause  units.Bytes `json:"pause,omitempty"`
+}
+
+func (t Threshold) level(free int64) Level {
+	switch {
+	case t.Pause > 0 && free < int64(t.Pause):
+		return Pause
+	case t.Notify > 0 && free < int64(t.Notify):
+		return Notify
+	case t.Warn > 0 && free < int64(t.Warn):
+		return Warn
+	}
+	return OK
+}
+
+// Guard 剩余空间守护
+type Guard struct {
+	Interval   timex.Duration `json:"interval,omitempty"` // 检查间隔，默认1分钟
+	Thresholds []Threshold    `json:"thresholds,omitempty"`
+}
+
+// DirState 单个目录的检查结果
+type DirState struct {
+	Usage
+	Level string `json:"level"`
+}
+
+// Run 定期检查 dirs 的剩余空间，更新状态和指标；整体级别(所有目录的最高级别)变化时调用 onChange，
+// 空间恢复后以 OK 级别再次调用。onChange 返回错误表示该级别未能生效，下一次检查时会再次调用。
+func (g Guard) Run(ctx context.Context, dirs []string, onChange func(level Level, states []DirState) error) {
+	ticker := time.NewTicker(g.Interval.Or(time.Minute))
+	defer ticker.Stop()
+
+	last := OK
+	for {
+		var (
+			states []DirState
+			level  = OK
+		)
+		for _, dir := range dirs {
+			u, err := Stat(dir)
+			if err != nil {
+				slog.DebugContext(ctx, "disk stat", "path", dir, "err", err)
+				continue
+			}
+
+			l := g.threshold(dir).level(u.Free)
+			level = max(level, l)
+			states = append(states, DirState{Usage: u, Level: l.String()})
+			metrics.SetGauge("xlpdok_disk_free_bytes", "Free bytes of the download directory filesystem", float64(u.Free), "path", dir)
+			metrics.SetGauge("xlpdok_disk_total_bytes", "Total bytes of the download directory filesystem", float64(u.Total), "path", dir)
+			if l > OK {
+				slog.WarnContext(ctx, "disk space low", "path", dir, "free", units.HumanBytes(u.Free), "level", l)
+			}
+		}
+		status.Set("disk", states)
+
+		if level != last {
+			slog.InfoContext(ctx, "disk guard level changed", "from", last, "to", level)
+			if err := onChange(level, states); err != nil {
+				slog.WarnContext(ctx, "disk guard level not applied, retry later", "level", level, "err", err)
+			} else {
+				last = level
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// threshold 优先使用路径完全匹配的阈值，其次使用未指定路径的阈值
+func (g Guard) threshold(dir string) (t Threshold) {
+	for _, item := range g.Thresholds {
+		if item.Path == dir {
+			return item
+		}
+		if item.Path == "" && t.Path == "" {
+			t = item
+		}
+	}
+	return
+}
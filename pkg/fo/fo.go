@@ -1,9 +1,11 @@
 package fo
 
 import (
+	"fmt"
 	"io/fs"
 	"os"
 	"path/filepath"
+	"strconv"
 )
 
 type Option func(file string) error
@@ -48,3 +50,12 @@ func Apply(file string, options ...Option) error {
 	}
 	return nil
 }
+
+// ParseMode 解析八进制权限字符串，如 "0664"
+func ParseMode(s string) (os.FileMode, error) {
+	mode, err := strconv.ParseUint(s, 8, 32)
+	if err != nil {
+		return 0, fmt.Errorf("mode is invalid: %s", s)
+	}
+	return os.FileMode(mode), nil
+}
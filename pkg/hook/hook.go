@@ -0,0 +1,145 @@
+package hook
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"slices"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"xlpdok/pkg/sys"
+	"xlpdok/pkg/timex"
+)
+
+// 钩子触发点
+const (
+	PreStart  = "pre-start"  // 文件系统准备完成，启动迅雷之前
+	PostStart = "post-start" // 迅雷进程启动之后
+	Exit      = "exit"       // 迅雷进程退出，环境变量 XL_EXIT_CODE 为退出码
+	SpkUpdate = "spk-update" // SPK 下载更新之后
+	Shutdown  = "shutdown"   // xlpdok 退出之前
+)
+
+const defaultTimeout = time.Minute
+
+// Hook 用户配置的钩子命令，命令通过 /bin/sh -c 执行
+type Hook struct {
+	On      string         `json:"on"`
+	Command string         `json:"command"`
+	Timeout timex.Duration `json:"timeout,omitempty"`
+}
+
+// Hooks 钩子集合
+type Hooks struct {
+	Uid, Gid int
+	Env      sys.EnvSet
+	List     []Hook
+}
+
+// Fire 按配置顺序执行所有匹配 event 的钩子，kv 为附加的环境变量键值对。
+// 钩子失败只记录日志，不影响主流程。
+//
+// 钩子从调用方所在的线程 fork，与迅雷处于同一命名空间：调用方锁定在已 unshare 的线程上时，
+// 钩子能看到私有的 /proc 和 /downloads 下的绑定。
+func (hs *Hooks) Fire(ctx context.Context, event string, kv ...string) {
+	if hs == nil {
+		return
+	}
+
+	env := slices.Clone(hs.Env).Sets(append([]string{"XL_HOOK_EVENT", event}, kv...)...)
+	for i, h := range hs.List {
+		if h.On != event || h.Command == "" {
+			continue
+		}
+
+		start := time.Now()
+		err := run(ctx, h, hs.Uid, hs.Gid, env)
+		if err != nil {
+			slog.WarnContext(ctx, "hook fail", "event", event, "index", i, "cost", time.Since(start), "err", err)
+		} else {
+			slog.InfoContext(ctx, "hook done", "event", event, "index", i, "cost", time.Since(start))
+		}
+	}
+}
+
+// Runner 将钩子包装为 sys.Runner，便于插入 sys.Exec 流程
+func (hs *Hooks) Runner(ctx context.Context, event string, kv ...string) sys.Runner {
+	return func() error { hs.Fire(ctx, event, kv...); return nil }
+}
+
+func run(ctx context.Context, h Hook, uid, gid int, env []string) (err error) {
+	// 关机钩子执行时 ctx 通常已取消，这里脱离父 ctx 的取消信号，只保留超时
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), h.Timeout.Or(defaultTimeout))
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", h.Command)
+	cmd.Env = env
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	// 实际用户为 root 时(包括 sys.RunAs 临时降权期间)总是切换到配置的用户，
+	// 否则 /bin/sh 会把有效用户重置为实际用户，钩子以 root 身份运行
+	if os.Getuid() == 0 {
+		cmd.SysProcAttr.Credential = &syscall.Credential{Uid: uint32(max(uid, 0)), Gid: uint32(max(gid, 0))}
+	}
+	cmd.Cancel = func() error { return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL) }
+
+	out := &lineLogger{ctx: ctx, attrs: []any{"event", h.On, "command", h.Command}}
+	cmd.Stdout, cmd.Stderr = out, out
+	defer out.Close()
+
+	if err = cmd.Run(); err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		err = cmp.Or(ctx.Err(), err)
+	}
+	return
+}
+
+// lineLogger 将钩子输出按行写入 slog
+type lineLogger struct {
+	ctx   context.Context
+	attrs []any
+	once  sync.Once
+	pw    *io.PipeWriter
+	done  chan struct{}
+}
+
+func (l *lineLogger) Write(p []byte) (int, error) {
+	l.once.Do(func() {
+		pr, pw := io.Pipe()
+		l.pw, l.done = pw, make(chan struct{})
+		go func() {
+			defer close(l.done)
+			for s := bufio.NewScanner(pr); s.Scan(); {
+				slog.InfoContext(l.ctx, "hook output: "+s.Text(), l.attrs...)
+			}
+			io.Copy(io.Discard, pr)
+		}()
+	})
+	return l.pw.Write(p)
+}
+
+func (l *lineLogger) Close() {
+	if l.pw != nil {
+		l.pw.Close()
+		<-l.done
+	}
+}
+
+// ExitCode 从 exec 错误中取得退出码
+func ExitCode(err error) string {
+	var ee *exec.ExitError
+	switch {
+	case err == nil:
+		return "0"
+	case errors.As(err, &ee):
+		return strconv.Itoa(ee.ExitCode())
+	default:
+		return "-1"
+	}
+}
@@ -0,0 +1,79 @@
+package httpx
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// Config 出站请求的代理和 TLS 设置
+type Config struct {
+	Proxy    string // 代理地址：http://、https://、socks5://，可带 user:pass@；为空时使用 HTTP_PROXY/HTTPS_PROXY/NO_PROXY 环境变量
+	Insecure bool   // 跳过证书校验
+	CAFile   string // 额外信任的 CA 证书(PEM)
+	CertFile string // 客户端证书(PEM)
+	KeyFile  string // 客户端私钥(PEM)
+}
+
+var transport atomic.Pointer[http.Transport]
+
+func init() { transport.Store(newTransport(http.ProxyFromEnvironment, &tls.Config{})) }
+
+// Setup 应用配置，之后所有通过 Client 发出的请求都使用该配置
+func Setup(c Config) (err error) {
+	proxy := http.ProxyFromEnvironment
+	if c.Proxy != "" {
+		u, e := url.Parse(c.Proxy)
+		if e != nil || u.Host == "" {
+			return fmt.Errorf("proxy is invalid: %s", c.Proxy)
+		}
+		switch u.Scheme {
+		case "http", "https", "socks5", "socks5h":
+		default:
+			return fmt.Errorf("proxy scheme is not support: %s", u.Scheme)
+		}
+		proxy = http.ProxyURL(u)
+	}
+
+	tc := &tls.Config{InsecureSkipVerify: c.Insecure}
+	if c.CAFile != "" {
+		pem, e := os.ReadFile(c.CAFile)
+		if e != nil {
+			return e
+		}
+		if tc.RootCAs, err = x509.SystemCertPool(); err != nil {
+			tc.RootCAs = x509.NewCertPool()
+		}
+		if !tc.RootCAs.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificate found in %s", c.CAFile)
+		}
+	}
+
+	if c.CertFile != "" || c.KeyFile != "" {
+		cert, e := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if e != nil {
+			return e
+		}
+		tc.Certificates = []tls.Certificate{cert}
+	}
+
+	transport.Store(newTransport(proxy, tc))
+	return nil
+}
+
+func newTransport(proxy func(*http.Request) (*url.URL, error), tc *tls.Config) *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.Proxy = proxy
+	t.TLSClientConfig = tc
+	return t
+}
+
+// Client 使用当前代理和 TLS 配置的 http.Client，timeout 为0表示不限
+func Client(timeout time.Duration) *http.Client {
+	return &http.Client{Transport: transport.Load(), Timeout: timeout}
+}
@@ -0,0 +1,62 @@
+package metrics
+
+import (
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type gauge struct {
+	help   string
+	values map[string]float64 // 标签串 -> 值
+}
+
+var (
+	mu     sync.RWMutex
+	gauges = map[string]*gauge{}
+)
+
+// SetGauge 设置指标值，labels 为键值对，如 SetGauge("xlpdok_disk_free_bytes", "...", 1024, "path", "/downloads")
+func SetGauge(name, help string, value float64, labels ...string) {
+	var b strings.Builder
+	for i := 0; i+1 < len(labels); i += 2 {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "%s=%s", labels[i], strconv.Quote(labels[i+1]))
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	g, find := gauges[name]
+	if !find {
+		g = &gauge{help: help, values: map[string]float64{}}
+		gauges[name] = g
+	}
+	g.values[b.String()] = value
+}
+
+// Handler 以 Prometheus 文本格式输出所有指标
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+		mu.RLock()
+		defer mu.RUnlock()
+		for _, name := range slices.Sorted(maps.Keys(gauges)) {
+			g := gauges[name]
+			fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", name, g.help, name)
+			for _, labels := range slices.Sorted(maps.Keys(g.values)) {
+				value := strconv.FormatFloat(g.values[labels], 'g', -1, 64)
+				if labels != "" {
+					labels = "{" + labels + "}"
+				}
+				fmt.Fprintf(w, "%s%s %s\n", name, labels, value)
+			}
+		}
+	})
+}
@@ -0,0 +1,183 @@
+package mount
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"xlpdok/pkg/timex"
+)
+
+// FILE_MOUNTINFO 当前线程所在挂载命名空间的挂载信息。
+// /proc/self 指向主线程，在已 unshare 的线程上读取会得到其他命名空间的挂载
+const FILE_MOUNTINFO = "/proc/thread-self/mountinfo"
+
+// Info mountinfo 中的一条挂载记录
+type Info struct {
+	Device     string // major:minor
+	Root       string
+	MountPoint string
+	FSType     string
+	Source     string
+}
+
+// Infos 读取当前线程所在挂载命名空间的所有挂载
+func Infos() ([]Info, error) { return infosIn(FILE_MOUNTINFO) }
+
+// ProcessInfo 进程 pid 所在挂载命名空间的挂载信息文件
+func ProcessInfo(pid int) string { return "/proc/" + strconv.Itoa(pid) + "/mountinfo" }
+
+// infosIn 读取 mountinfo 格式的文件
+func infosIn(file string) (infos []Info, err error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	for s := bufio.NewScanner(f); s.Scan(); {
+		// 36 35 98:0 /mnt1 /mnt2 rw,noatime master:1 - ext3 /dev/root rw,errors=continue
+		fields := strings.Fields(s.Text())
+		sep := slices.Index(fields, "-")
+		if sep < 6 || len(fields) < sep+3 {
+			continue
+		}
+		infos = append(infos, Info{
+			Device:     fields[2],
+			Root:       unescape(fields[3]),
+			MountPoint: unescape(fields[4]),
+			FSType:     fields[sep+1],
+			Source:     unescape(fields[sep+2]),
+		})
+	}
+	return
+}
+
+// Find 在当前线程的挂载命名空间中查找 path 所在的挂载(挂载点最长匹配，同一挂载点取最后挂载的)
+func Find(path string) (Info, error) { return findIn(FILE_MOUNTINFO, path) }
+
+func findIn(mountinfo, path string) (info Info, err error) {
+	if path, err = filepath.EvalSymlinks(path); err != nil {
+		return
+	}
+
+	infos, err := infosIn(mountinfo)
+	if err != nil {
+		return
+	}
+
+	found := false
+	for _, m := range infos {
+		if m.MountPoint == path || m.MountPoint == "/" || strings.HasPrefix(path, m.MountPoint+"/") {
+			if !found || len(m.MountPoint) >= len(info.MountPoint) {
+				info, found = m, true
+			}
+		}
+	}
+	if !found {
+		err = fmt.Errorf("mount not found: %s", path)
+	}
+	return
+}
+
+// mountinfo 中空格、制表符、换行和反斜杠以 \ooo 八进制转义
+func unescape(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+4 <= len(s) {
+			if n, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(n))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// 检查失败时运行中的处理方式，启动时检查失败总是拒绝启动
+const (
+	ActionWarn   = "warn"   // 记录日志
+	ActionNotify = "notify" // 记录日志并推送通知
+	ActionStop   = "stop"   // 停止迅雷并以非零状态退出
+)
+
+// Require 目录挂载要求，未设置的条件忽略
+type Require struct {
+	Mountpoint bool           `json:"mountpoint,omitempty"` // 目录本身必须是挂载点
+	FSTypes    []string       `json:"fs_types,omitempty"`   // 允许的文件系统类型，如 ["ext4", "btrfs"]
+	Sources    []string       `json:"sources,omitempty"`    // 允许的设备，如 ["/dev/sdb1"]
+	Interval   timex.Duration `json:"interval,omitempty"`   // 运行中检查间隔，默认1分钟
+	Action     string         `json:"action,omitempty"`     // 运行中检查失败的处理：warn, notify, stop(默认)
+}
+
+// Check 在当前线程的挂载命名空间中检查 dir 是否满足要求
+func (r Require) Check(dir string) error { return r.checkIn(FILE_MOUNTINFO, dir) }
+
+func (r Require) checkIn(mountinfo, dir string) (err error) {
+	resolved, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return
+	}
+
+	info, err := findIn(mountinfo, resolved)
+	if err != nil {
+		return
+	}
+
+	switch {
+	case r.Mountpoint && info.MountPoint != resolved:
+		return fmt.Errorf("%s is not a mountpoint, it is on %s", dir, info.MountPoint)
+	case len(r.FSTypes) > 0 && !slices.Contains(r.FSTypes, info.FSType):
+		return fmt.Errorf("%s is on filesystem %s, want %s", dir, info.FSType, strings.Join(r.FSTypes, ","))
+	case len(r.Sources) > 0 && !slices.Contains(r.Sources, info.Source):
+		return fmt.Errorf("%s is on device %s, want %s", dir, info.Source, strings.Join(r.Sources, ","))
+	}
+	return
+}
+
+// CheckAll 依次检查所有目录
+func (r Require) CheckAll(dirs []string) error { return r.checkAllIn(FILE_MOUNTINFO, dirs) }
+
+func (r Require) checkAllIn(mountinfo string, dirs []string) error {
+	for _, dir := range dirs {
+		if err := r.checkIn(mountinfo, dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Watch 定期在进程 pid() 的挂载命名空间中检查，检查失败时调用 onFail，直到 ctx 结束。
+// 检查在其他线程上进行，不能读取当前线程的挂载信息；pid() 为0(进程未运行)时跳过
+func (r Require) Watch(ctx context.Context, dirs []string, pid func() int, onFail func(err error)) {
+	ticker := time.NewTicker(r.Interval.Or(time.Minute))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		p := pid()
+		if p == 0 {
+			continue
+		}
+		if err := r.checkAllIn(ProcessInfo(p), dirs); err != nil {
+			slog.ErrorContext(ctx, "mount check fail", "err", err)
+			onFail(err)
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package mount
+
+import (
+	"os"
+	"testing"
+)
+
+func TestFind(t *testing.T) {
+	info, err := Find("/")
+	if err != nil || info.MountPoint != "/" {
+		t.Fatalf("Find(/) = %+v, %v", info, err)
+	}
+
+	// 进程的挂载信息与当前线程一致(未 unshare)
+	self, err := findIn(ProcessInfo(os.Getpid()), "/")
+	if err != nil || self != info {
+		t.Fatalf("findIn(pid) = %+v, %v, want %+v", self, err, info)
+	}
+
+	if err = (Require{Mountpoint: true}).Check(t.TempDir()); err == nil {
+		t.Error("temp dir should not be a mountpoint")
+	}
+}
+
+func TestUnescape(t *testing.T) {
+	if got := unescape(`/mnt/my\040disk\134x`); got != `/mnt/my disk\x` {
+		t.Fatalf("unescape = %q", got)
+	}
+}
@@ -0,0 +1,195 @@
+package notify
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"text/template"
+	"time"
+
+	"xlpdok/pkg/httpx"
+)
+
+// 包装器产生的事件
+const (
+	DownloadCompleted = "download.completed" // Data: path, size, duration
+	XunleiCrashed     = "xunlei.crashed"     // Data: exit_code, err
+	SpkUpdated        = "spk.updated"        // Data: version, old_version
+	DiskLow           = "disk.low"           // Data: level, dirs
+	MountFailed       = "mount.failed"       // Data: err
+	LoginRequired     = "login.required"     // Data: dir
+)
+
+// Event 通知事件
+type Event struct {
+	Name     string         `json:"name"`
+	Instance string         `json:"instance,omitempty"`
+	Time     time.Time      `json:"time"`
+	Data     map[string]any `json:"data,omitempty"`
+}
+
+// Template 消息模板，使用 text/template 语法，数据为 Event
+type Template struct {
+	Title string `json:"title,omitempty"`
+	Body  string `json:"body,omitempty"`
+}
+
+// DefaultTemplates 各事件的默认模板
+var DefaultTemplates = map[string]Template{
+	DownloadCompleted: {Title: "下载完成", Body: "{{.Data.path}} ({{.Data.size}})"},
+	XunleiCrashed:     {Title: "迅雷异常退出", Body: "退出码 {{.Data.exit_code}}: {{.Data.err}}"},
+	SpkUpdated:        {Title: "迅雷已更新", Body: "{{.Data.old_version}} -> {{.Data.version}}"},
+	MountFailed:       {Title: "下载目录挂载异常", Body: "{{.Data.err}}"},
+	LoginRequired:     {Title: "迅雷需要登录", Body: "请打开面板扫码登录，账号目录 {{.Data.dir}} 中没有登录信息"},
+	DiskLow:           {Title: "磁盘空间不足", Body: "{{range .Data.dirs}}{{.Path}} 剩余 {{.Free}} 字节\n{{end}}"},
+}
+
+// Notifier 推送渠道配置
+type Notifier struct {
+	Type      string              `json:"type"`                // telegram, bark, serverchan, ntfy, gotify
+	Server    string              `json:"server,omitempty"`    // 服务地址，为空使用官方地址(gotify 必填)
+	Token     string              `json:"token,omitempty"`     // telegram bot token, bark device key, serverchan sendkey, ntfy access token, gotify app token
+	ChatID    string              `json:"chat_id,omitempty"`   // telegram
+	Topic     string              `json:"topic,omitempty"`     // ntfy
+	Events    []string            `json:"events,omitempty"`    // 需要推送的事件，为空推送全部
+	Templates map[string]Template `json:"templates,omitempty"` // 按事件覆盖默认模板
+}
+
+// Hub 推送到所有配置的渠道
+type Hub []Notifier
+
+// Notify 按事件路由推送，失败只记录日志
+func (hub Hub) Notify(ctx context.Context, ev Event) {
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+
+	for _, n := range hub {
+		if len(n.Events) > 0 && !slices.Contains(n.Events, ev.Name) {
+			continue
+		}
+
+		title, body, err := n.render(ev)
+		if err == nil {
+			err = n.send(ctx, title, body)
+		}
+		if err != nil {
+			slog.WarnContext(ctx, "notify fail", "type", n.Type, "event", ev.Name, "err", err)
+		} else {
+			slog.DebugContext(ctx, "notify sent", "type", n.Type, "event", ev.Name)
+		}
+	}
+}
+
+func (n Notifier) render(ev Event) (title, body string, err error) {
+	t, find := n.Templates[ev.Name]
+	if !find {
+		t = DefaultTemplates[ev.Name]
+	}
+	t.Title, t.Body = cmp.Or(t.Title, ev.Name), cmp.Or(t.Body, "{{.Data}}")
+
+	if title, err = execute(t.Title, ev); err != nil {
+		return
+	}
+	if body, err = execute(t.Body, ev); err != nil {
+		return
+	}
+	if ev.Instance != "" {
+		title = "[" + ev.Instance + "] " + title
+	}
+	return
+}
+
+func execute(text string, ev Event) (string, error) {
+	t, err := template.New("").Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	err = t.Execute(&b, ev)
+	return b.String(), err
+}
+
+func (n Notifier) send(ctx context.Context, title, body string) error {
+	switch n.Type {
+	case "telegram":
+		u := cmp.Or(n.Server, "https://api.telegram.org") + "/bot" + n.Token + "/sendMessage"
+		return postJSON(ctx, u, nil, map[string]any{"chat_id": n.ChatID, "text": title + "\n" + body})
+	case "bark":
+		u := cmp.Or(n.Server, "https://api.day.app") + "/push"
+		return postJSON(ctx, u, nil, map[string]any{"device_key": n.Token, "title": title, "body": body, "group": "xlpdok"})
+	case "serverchan":
+		u := cmp.Or(n.Server, "https://sctapi.ftqq.com") + "/" + n.Token + ".send"
+		form := url.Values{"title": {title}, "desp": {body}}
+		return post(ctx, u, "application/x-www-form-urlencoded", nil, strings.NewReader(form.Encode()))
+	case "ntfy":
+		u := cmp.Or(n.Server, "https://ntfy.sh") + "/" + url.PathEscape(n.Topic)
+		header := http.Header{"Title": {title}, "Tags": {"xlpdok"}}
+		if n.Token != "" {
+			header.Set("Authorization", "Bearer "+n.Token)
+		}
+		return post(ctx, u, "text/plain; charset=utf-8", header, strings.NewReader(body))
+	case "gotify":
+		if n.Server == "" {
+			return fmt.Errorf("gotify server is required")
+		}
+		return postJSON(ctx, n.Server+"/message", http.Header{"X-Gotify-Key": {n.Token}}, map[string]any{"title": title, "message": body, "priority": 5})
+	default:
+		return fmt.Errorf("notifier type is not support: %s", n.Type)
+	}
+}
+
+func postJSON(ctx context.Context, u string, header http.Header, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return post(ctx, u, "application/json", header, bytes.NewReader(data))
+}
+
+func post(ctx context.Context, u, contentType string, header http.Header, body io.Reader) (err error) {
+	defer func() { err = redact(err) }()
+
+	var req *http.Request
+	if req, err = http.NewRequestWithContext(ctx, http.MethodPost, u, body); err != nil {
+		return
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", contentType)
+
+	var resp *http.Response
+	if resp, err = httpx.Client(15 * time.Second).Do(req); err != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		err = fmt.Errorf("unexpected status: %s %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return
+}
+
+// redact 去掉 *url.Error 中地址的路径和参数，telegram、serverchan 的 token 在路径中，不能随错误写入日志
+func redact(err error) error {
+	var ue *url.Error
+	if errors.As(err, &ue) {
+		if u, e := url.Parse(ue.URL); e == nil {
+			ue.URL = u.Scheme + "://" + u.Host
+		} else {
+			ue.URL = ""
+		}
+	}
+	return err
+}
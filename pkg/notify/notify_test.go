@@ -0,0 +1,189 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// request 桩服务收到的请求
+type request struct {
+	Method, Path, Query, ContentType string
+	Header                           http.Header
+	Body                             string
+}
+
+// stub 记录请求的本地服务，模拟各推送服务的接口
+func stub(t *testing.T, status int) (srv *httptest.Server, got func() []request) {
+	t.Helper()
+	var (
+		mu   sync.Mutex
+		reqs []request
+	)
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		reqs = append(reqs, request{r.Method, r.URL.Path, r.URL.RawQuery, r.Header.Get("Content-Type"), r.Header.Clone(), string(body)})
+		mu.Unlock()
+		w.WriteHeader(status)
+		io.WriteString(w, `{"ok":true}`)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, func() []request {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]request(nil), reqs...)
+	}
+}
+
+func decodeJSON(t *testing.T, body string) (v map[string]any) {
+	t.Helper()
+	if err := json.Unmarshal([]byte(body), &v); err != nil {
+		t.Fatalf("body is not json: %v: %s", err, body)
+	}
+	return
+}
+
+func TestProviders(t *testing.T) {
+	ev := Event{Name: DownloadCompleted, Data: map[string]any{"path": "/downloads/a.mkv", "size": "1.00 GiB"}}
+	const title, body = "下载完成", "/downloads/a.mkv (1.00 GiB)"
+
+	tests := []struct {
+		notifier Notifier
+		check    func(t *testing.T, r request)
+	}{
+		{Notifier{Type: "telegram", Token: "123:abc", ChatID: "42"}, func(t *testing.T, r request) {
+			if r.Path != "/bot123:abc/sendMessage" || r.ContentType != "application/json" {
+				t.Errorf("path=%s content-type=%s", r.Path, r.ContentType)
+			}
+			v := decodeJSON(t, r.Body)
+			if v["chat_id"] != "42" || v["text"] != title+"\n"+body {
+				t.Errorf("body=%v", v)
+			}
+		}},
+		{Notifier{Type: "bark", Token: "device"}, func(t *testing.T, r request) {
+			if r.Path != "/push" {
+				t.Errorf("path=%s", r.Path)
+			}
+			v := decodeJSON(t, r.Body)
+			if v["device_key"] != "device" || v["title"] != title || v["body"] != body || v["group"] != "xlpdok" {
+				t.Errorf("body=%v", v)
+			}
+		}},
+		{Notifier{Type: "serverchan", Token: "SCT1"}, func(t *testing.T, r request) {
+			if r.Path != "/SCT1.send" || r.ContentType != "application/x-www-form-urlencoded" {
+				t.Errorf("path=%s content-type=%s", r.Path, r.ContentType)
+			}
+			form, err := url.ParseQuery(r.Body)
+			if err != nil || form.Get("title") != title || form.Get("desp") != body {
+				t.Errorf("form=%v err=%v", form, err)
+			}
+		}},
+		{Notifier{Type: "ntfy", Topic: "xl downloads", Token: "tk"}, func(t *testing.T, r request) {
+			if r.Path != "/xl downloads" || !strings.HasPrefix(r.ContentType, "text/plain") {
+				t.Errorf("path=%s content-type=%s", r.Path, r.ContentType)
+			}
+			if r.Header.Get("Title") != title || r.Header.Get("Authorization") != "Bearer tk" || r.Body != body {
+				t.Errorf("header=%v body=%q", r.Header, r.Body)
+			}
+		}},
+		{Notifier{Type: "gotify", Token: "app&token"}, func(t *testing.T, r request) {
+			if r.Path != "/message" || r.Query != "" || r.Header.Get("X-Gotify-Key") != "app&token" {
+				t.Errorf("path=%s query=%s header=%v", r.Path, r.Query, r.Header)
+			}
+			v := decodeJSON(t, r.Body)
+			if v["title"] != title || v["message"] != body || v["priority"] != float64(5) {
+				t.Errorf("body=%v", v)
+			}
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.notifier.Type, func(t *testing.T) {
+			srv, got := stub(t, http.StatusOK)
+			n := tt.notifier
+			n.Server = srv.URL
+			if err := n.send(context.Background(), title, body); err != nil {
+				t.Fatal(err)
+			}
+
+			Hub{n}.Notify(context.Background(), ev)
+			reqs := got()
+			if len(reqs) != 2 {
+				t.Fatalf("requests = %d, want 2", len(reqs))
+			}
+			for _, r := range reqs {
+				if r.Method != http.MethodPost {
+					t.Errorf("method=%s", r.Method)
+				}
+				tt.check(t, r)
+			}
+		})
+	}
+}
+
+func TestSendError(t *testing.T) {
+	srv, _ := stub(t, http.StatusUnauthorized)
+	err := Notifier{Type: "bark", Server: srv.URL}.send(context.Background(), "t", "b")
+	if err == nil || !strings.Contains(err.Error(), "401") {
+		t.Fatalf("err = %v, want unexpected status 401", err)
+	}
+
+	// 网络错误中不能带出路径里的 token
+	srv.Close()
+	err = Notifier{Type: "telegram", Server: srv.URL, Token: "123:secret"}.send(context.Background(), "t", "b")
+	if err == nil || strings.Contains(err.Error(), "secret") {
+		t.Fatalf("err = %v, want an error without the token", err)
+	}
+
+	if err = (Notifier{Type: "gotify"}).send(context.Background(), "t", "b"); err == nil {
+		t.Fatal("gotify without server should fail")
+	}
+	if err = (Notifier{Type: "unknown"}).send(context.Background(), "t", "b"); err == nil {
+		t.Fatal("unknown type should fail")
+	}
+}
+
+func TestRouting(t *testing.T) {
+	srv, got := stub(t, http.StatusOK)
+	hub := Hub{
+		{Type: "ntfy", Server: srv.URL, Topic: "all"},
+		{Type: "ntfy", Server: srv.URL, Topic: "login", Events: []string{LoginRequired}},
+	}
+
+	hub.Notify(context.Background(), Event{Name: DownloadCompleted, Data: map[string]any{"path": "a", "size": "1 B"}})
+	hub.Notify(context.Background(), Event{Name: LoginRequired, Data: map[string]any{"dir": "/xunlei/data"}})
+
+	var paths []string
+	for _, r := range got() {
+		paths = append(paths, r.Path)
+	}
+	if want := "/all,/all,/login"; strings.Join(paths, ",") != want {
+		t.Fatalf("paths = %v, want %s", paths, want)
+	}
+}
+
+func TestRender(t *testing.T) {
+	n := Notifier{Templates: map[string]Template{XunleiCrashed: {Title: "crash {{.Data.exit_code}}"}}}
+
+	title, body, err := n.render(Event{Name: XunleiCrashed, Instance: "a", Data: map[string]any{"exit_code": "2", "err": "boom"}})
+	if err != nil || title != "[a] crash 2" || body != "map[err:boom exit_code:2]" {
+		t.Fatalf("title=%q body=%q err=%v", title, body, err)
+	}
+
+	title, body, err = n.render(Event{Name: LoginRequired, Data: map[string]any{"dir": "/xunlei/data"}})
+	if err != nil || title != "迅雷需要登录" || !strings.Contains(body, "/xunlei/data") {
+		t.Fatalf("title=%q body=%q err=%v", title, body, err)
+	}
+
+	title, body, err = n.render(Event{Name: "custom.event", Data: map[string]any{"k": "v"}})
+	if err != nil || title != "custom.event" || body != "map[k:v]" {
+		t.Fatalf("title=%q body=%q err=%v", title, body, err)
+	}
+}
@@ -0,0 +1,88 @@
+package perm
+
+import (
+	"context"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"xlpdok/pkg/fo"
+	"xlpdok/pkg/sys"
+	"xlpdok/pkg/watch"
+)
+
+// Policy 下载目录中新文件的属主和权限
+type Policy struct {
+	Uid      int    `json:"uid,omitempty"`       // 与 Gid 均为0时不修改属主
+	Gid      int    `json:"gid,omitempty"`       //
+	FileMode string `json:"file_mode,omitempty"` // 文件权限，八进制，如 "0666"
+	DirMode  string `json:"dir_mode,omitempty"`  // 目录权限，八进制，如 "0777"
+}
+
+// Options 转换为文件和目录各自的 fo.Option
+func (p Policy) Options() (file, dir []fo.Option, err error) {
+	if p.Uid != 0 || p.Gid != 0 {
+		file = append(file, fo.Chown(p.Uid, p.Gid))
+		dir = append(dir, fo.Chown(p.Uid, p.Gid))
+	}
+
+	if p.FileMode != "" {
+		mode, e := fo.ParseMode(p.FileMode)
+		if e != nil {
+			return nil, nil, e
+		}
+		file = append(file, fo.Chmod(mode))
+	}
+
+	if p.DirMode != "" {
+		mode, e := fo.ParseMode(p.DirMode)
+		if e != nil {
+			return nil, nil, e
+		}
+		dir = append(dir, fo.Chmod(mode))
+	}
+	return
+}
+
+// Enforce 监听 roots，对新建、移入和写入完成的条目应用策略，直到 ctx 结束。
+// 移入的目录会递归处理其中已有的内容。
+func Enforce(ctx context.Context, roots []string, p Policy) (err error) {
+	fileOptions, dirOptions, err := p.Options()
+	if err != nil || len(fileOptions)+len(dirOptions) == 0 {
+		return
+	}
+
+	apply := func(path string, isDir bool) {
+		// 不跟随符号链接，避免修改下载目录之外的文件
+		if fi, e := os.Lstat(path); e != nil || fi.Mode()&fs.ModeSymlink != 0 {
+			return
+		}
+		options := fileOptions
+		if isDir {
+			options = dirOptions
+		}
+		if e := sys.Privileged(func() error { return fo.Apply(path, options...) }); e != nil {
+			slog.DebugContext(ctx, "enforce ownership", "path", path, "err", e)
+		}
+	}
+
+	return watch.Watch(ctx, roots, syscall.IN_CREATE|syscall.IN_MOVED_TO|syscall.IN_CLOSE_WRITE, func(ev watch.Event) {
+		if !ev.IsDir() {
+			apply(ev.Path, false)
+			return
+		}
+
+		if ev.Has(syscall.IN_MOVED_TO) {
+			filepath.WalkDir(ev.Path, func(path string, d fs.DirEntry, err error) error {
+				if err == nil && d.Type()&fs.ModeSymlink == 0 {
+					apply(path, d.IsDir())
+				}
+				return nil
+			})
+			return
+		}
+		apply(ev.Path, true)
+	})
+}
@@ -0,0 +1,259 @@
+package rule
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/template"
+	"time"
+
+	"xlpdok/pkg/fo"
+	"xlpdok/pkg/sys"
+)
+
+// 规则动作
+const (
+	Move     = "move"     // 移动到 Target 目录
+	Rename   = "rename"   // 按 Target 模板重命名，相对路径基于文件所在目录
+	Hardlink = "hardlink" // 硬链接到 Target 目录(如 Plex/Jellyfin 媒体库)，原文件保留，忽略 Uid、Gid 和 Mode
+)
+
+// Rule 下载完成后的处理规则，匹配条件之间为"且"关系，未设置的条件忽略
+type Rule struct {
+	Name    string   `json:"name,omitempty"`
+	Glob    string   `json:"glob,omitempty"`     // 匹配文件名，filepath.Match 语法
+	Regex   Regexp   `json:"regex,omitzero"`     // 匹配完整路径，分组可在模板中用 .Match 引用
+	Ext     []string `json:"ext,omitempty"`      // 扩展名，不区分大小写，如 [".mkv", ".mp4"]
+	MinSize int64    `json:"min_size,omitempty"` // 最小字节数
+	MaxSize int64    `json:"max_size,omitempty"` // 最大字节数
+
+	Action string `json:"action"`            // move, rename, hardlink
+	Target string `json:"target"`            // 目标，text/template 语法，数据为 Data
+	Uid    int    `json:"uid,omitempty"`     // 目标属主，与 Gid 均为0时不修改，硬链接时忽略
+	Gid    int    `json:"gid,omitempty"`     //
+	Mode   string `json:"mode,omitempty"`    // 目标权限，八进制，如 "0664"，硬链接时忽略
+	DryRun bool   `json:"dry_run,omitempty"` // 只记录日志，不执行
+}
+
+// Regexp 在JSON配置中写成字符串，解析配置时编译，无效的表达式在启动时报错
+type Regexp struct{ *regexp.Regexp }
+
+func (r Regexp) MarshalJSON() ([]byte, error) {
+	if r.Regexp == nil {
+		return json.Marshal("")
+	}
+	return json.Marshal(r.String())
+}
+
+func (r *Regexp) UnmarshalJSON(b []byte) (err error) {
+	var s string
+	if err = json.Unmarshal(b, &s); err != nil || s == "" {
+		return
+	}
+	r.Regexp, err = regexp.Compile(s)
+	return
+}
+
+// Data 目标模板的数据
+type Data struct {
+	Path  string    // 完整路径
+	Dir   string    // 所在目录
+	Name  string    // 文件名
+	Stem  string    // 不含扩展名的文件名
+	Ext   string    // 扩展名，含点
+	Size  int64     //
+	Time  time.Time // 处理时间
+	Match []string  // Regex 的匹配分组
+}
+
+// Rules 按顺序匹配，第一条命中的规则生效
+type Rules []Rule
+
+// Apply 对下载完成的文件执行规则，返回处理后的路径(未处理或 dry-run 时返回原路径)
+func (rs Rules) Apply(ctx context.Context, path string, size int64) string {
+	for i, r := range rs {
+		match, ok, err := r.match(path, size)
+		if err != nil {
+			slog.WarnContext(ctx, "rule match", "rule", r.label(i), "err", err)
+			continue
+		}
+		if !ok {
+			continue
+		}
+
+		name := filepath.Base(path)
+		ext := filepath.Ext(name)
+		data := Data{Path: path, Dir: filepath.Dir(path), Name: name, Stem: strings.TrimSuffix(name, ext), Ext: ext, Size: size, Time: time.Now(), Match: match}
+		target, err := r.apply(ctx, data)
+		if err != nil {
+			slog.WarnContext(ctx, "rule apply", "rule", r.label(i), "action", r.Action, "path", path, "err", err)
+			return path
+		}
+
+		slog.InfoContext(ctx, "rule apply", "rule", r.label(i), "action", r.Action, "path", path, "target", target, "dry_run", r.DryRun)
+		if r.DryRun || r.Action == Hardlink {
+			return path
+		}
+		return target
+	}
+	return path
+}
+
+func (r Rule) label(i int) string {
+	if r.Name != "" {
+		return r.Name
+	}
+	return "#" + strconv.Itoa(i)
+}
+
+func (r Rule) match(path string, size int64) (match []string, ok bool, err error) {
+	if r.Glob != "" {
+		if ok, err = filepath.Match(r.Glob, filepath.Base(path)); !ok || err != nil {
+			return
+		}
+	}
+
+	if r.Regex.Regexp != nil {
+		if match = r.Regex.FindStringSubmatch(path); match == nil {
+			return
+		}
+	}
+
+	if len(r.Ext) > 0 && !slices.ContainsFunc(r.Ext, func(ext string) bool { return strings.EqualFold(ext, filepath.Ext(path)) }) {
+		return
+	}
+
+	if (r.MinSize > 0 && size < r.MinSize) || (r.MaxSize > 0 && size > r.MaxSize) {
+		return
+	}
+	return match, true, nil
+}
+
+func (r Rule) apply(ctx context.Context, data Data) (target string, err error) {
+	if target, err = render(r.Target, data); err != nil {
+		return
+	}
+	if target == "" {
+		return "", fmt.Errorf("target is empty")
+	}
+
+	switch r.Action {
+	case Move, Hardlink:
+		target = filepath.Join(target, data.Name)
+	case Rename:
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(data.Dir, target)
+		}
+	default:
+		return "", fmt.Errorf("action is not support: %s", r.Action)
+	}
+
+	// 硬链接与原文件共享 inode，修改属主和权限会同时改变原文件，因此不修改
+	var options []fo.Option
+	if r.Action != Hardlink && (r.Uid != 0 || r.Gid != 0) {
+		options = append(options, fo.Chown(r.Uid, r.Gid))
+	}
+	if r.Action != Hardlink && r.Mode != "" {
+		mode, e := fo.ParseMode(r.Mode)
+		if e != nil {
+			return "", e
+		}
+		options = append(options, fo.Chmod(mode))
+	}
+
+	if r.DryRun {
+		return
+	}
+
+	if _, e := os.Lstat(target); e == nil {
+		return "", fmt.Errorf("target exists: %s", target)
+	}
+	if err = os.MkdirAll(filepath.Dir(target), 0o777); err != nil {
+		return
+	}
+
+	if r.Action == Hardlink {
+		err = os.Link(data.Path, target)
+	} else {
+		err = move(data.Path, target)
+	}
+	if err != nil {
+		return
+	}
+
+	// 规则在 sys.RunAs 降权后执行，修改属主需要临时恢复 root 身份
+	if err = sys.Privileged(func() error { return fo.Apply(target, options...) }); err != nil {
+		slog.WarnContext(ctx, "rule set owner", "target", target, "err", err)
+		err = nil
+	}
+	return
+}
+
+func render(text string, data Data) (string, error) {
+	t, err := template.New("").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	err = t.Execute(&b, data)
+	return strings.TrimSpace(b.String()), err
+}
+
+// stagingPrefix 跨文件系统移动时先复制到目标目录中以此为前缀的临时文件，完成后重命名
+const stagingPrefix = ".moving-"
+
+// Staging 是否为移动中的临时文件。目标在下载目录中时，复制产生的写入事件不应被当作新的下载完成
+func Staging(path string) bool {
+	return strings.HasPrefix(filepath.Base(path), stagingPrefix)
+}
+
+// move 重命名，跨文件系统时复制到临时文件后重命名为 dst，再删除 src
+func move(src, dst string) (err error) {
+	if err = os.Rename(src, dst); !errors.Is(err, syscall.EXDEV) {
+		return
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return
+	}
+	defer in.Close()
+
+	stat, err := in.Stat()
+	if err != nil {
+		return
+	}
+
+	out, err := os.CreateTemp(filepath.Dir(dst), stagingPrefix)
+	if err != nil {
+		return
+	}
+
+	if _, err = io.Copy(out, in); err == nil {
+		err = out.Chmod(stat.Mode().Perm())
+	}
+	if err == nil {
+		err = out.Sync()
+	}
+	if ce := out.Close(); err == nil {
+		err = ce
+	}
+	if err == nil {
+		err = os.Rename(out.Name(), dst)
+	}
+	if err != nil {
+		os.Remove(out.Name())
+		return
+	}
+	return os.Remove(src)
+}
@@ -0,0 +1,41 @@
+package rule
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRegexConfig(t *testing.T) {
+	var rs Rules
+	if err := json.Unmarshal([]byte(`[{"regex":"(", "action":"move", "target":"/x"}]`), &rs); err == nil {
+		t.Fatal("invalid regex should fail when the config is parsed")
+	}
+
+	if err := json.Unmarshal([]byte(`[{"regex":"S(\\d+)E(\\d+)", "action":"rename", "target":"s{{index .Match 1}}e{{index .Match 2}}{{.Ext}}"}]`), &rs); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := json.Marshal(rs[0]); err != nil || string(data) != `{"regex":"S(\\d+)E(\\d+)","action":"rename","target":"s{{index .Match 1}}e{{index .Match 2}}{{.Ext}}"}` {
+		t.Fatalf("marshal = %s, %v", data, err)
+	}
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, "Show.S01E02.mkv")
+	if err := os.WriteFile(src, []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := rs.Apply(context.Background(), src, 1), filepath.Join(dir, "s01e02.mkv"); got != want {
+		t.Fatalf("Apply = %s, want %s", got, want)
+	}
+}
+
+func TestStaging(t *testing.T) {
+	if !Staging("/downloads/movies/" + stagingPrefix + "123") {
+		t.Error("staging file not detected")
+	}
+	if Staging("/downloads/movies/a.mkv") {
+		t.Error("regular file detected as staging")
+	}
+}
@@ -0,0 +1,237 @@
+package spk
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"xlpdok/pkg/units"
+)
+
+// WithCacheDir 将下载的 SPK 保存在 dir 中：中断的下载用 Range 续传，
+// 已缓存的 SPK 先用 HEAD 请求比对上游的 ETag、Last-Modified 和大小，未变化时直接用于解压(包括 force 重新安装)，
+// 无法联网时也直接使用缓存
+func WithCacheDir(dir string) Option {
+	return func(o *options) { o.cacheDir = dir }
+}
+
+// WithRefresh 忽略已缓存的 SPK 重新下载，下载成功后替换缓存
+func WithRefresh() Option {
+	return func(o *options) { o.refresh = true }
+}
+
+// CachedFile SPK 在缓存目录中的路径，文件名为地址摘要的前缀加地址路径中的文件名：
+// 查询参数(如镜像的签名)不进入文件名，只有查询参数不同的地址也不共用缓存
+func CachedFile(cacheDir, spkUrl string) string {
+	name := path.Base(spkUrl)
+	if u, err := url.Parse(spkUrl); err == nil {
+		name = path.Base(u.Path)
+	}
+	sum := sha256.Sum256([]byte(spkUrl))
+	return filepath.Join(cacheDir, hex.EncodeToString(sum[:6])+"-"+name)
+}
+
+func download_cached(ctx context.Context, spkUrl string, dir string, o options) (err error) {
+	cached := CachedFile(o.cacheDir, spkUrl)
+	fetched := false
+	if _, e := os.Stat(cached); e != nil || o.refresh || cacheStale(ctx, spkUrl, cached) {
+		if err = fetchToCache(ctx, spkUrl, cached, o); err != nil {
+			return
+		}
+		fetched = true
+	} else {
+		slog.InfoContext(ctx, "use cached spk", "file", cached)
+	}
+
+	for {
+		if err = download_file(ctx, "file://"+cached, dir, o); err == nil || ctx.Err() != nil {
+			return
+		}
+
+		// 缓存已损坏或上游已更新，删除缓存；使用的是旧缓存时重新下载一次
+		slog.WarnContext(ctx, "remove cached spk", "file", cached, "err", err)
+		removeCache(cached)
+		if fetched {
+			return
+		}
+		if err = fetchToCache(ctx, spkUrl, cached, o); err != nil {
+			return
+		}
+		fetched = true
+	}
+}
+
+// cacheValidator 缓存文件对应的上游响应头，用于判断缓存是否过期
+type cacheValidator struct {
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+	Size         int64  `json:"size,omitempty"`
+}
+
+func validatorOf(h http.Header, size int64) cacheValidator {
+	v := cacheValidator{LastModified: h.Get("Last-Modified"), Size: max(size, 0)}
+	if etag := h.Get("ETag"); !strings.HasPrefix(etag, "W/") {
+		v.ETag = etag
+	}
+	return v
+}
+
+// cacheStale 用 HEAD 请求比对上游与缓存的 ETag、Last-Modified 和大小，任一可比较的值不同即过期。
+// 请求失败时按未过期处理，离线时仍可使用缓存
+func cacheStale(ctx context.Context, spkUrl, cached string) bool {
+	var old cacheValidator
+	if data, err := os.ReadFile(cached + ".validator"); err == nil {
+		json.Unmarshal(data, &old)
+	}
+	if stat, err := os.Stat(cached); err == nil {
+		old.Size = stat.Size()
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, infoTimeout)
+	defer cancel()
+	req, err := newRequest(ctx, http.MethodHead, spkUrl)
+	if err != nil {
+		return false
+	}
+	resp, err := httpClient().Do(req)
+	if err != nil {
+		slog.DebugContext(ctx, "revalidate cached spk", "url", spkUrl, "err", err)
+		return false
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		slog.DebugContext(ctx, "revalidate cached spk", "url", spkUrl, "status", resp.Status)
+		return false
+	}
+
+	cur := validatorOf(resp.Header, resp.ContentLength)
+	stale := (old.ETag != "" && cur.ETag != "" && old.ETag != cur.ETag) ||
+		(old.LastModified != "" && cur.LastModified != "" && old.LastModified != cur.LastModified) ||
+		(old.Size > 0 && cur.Size > 0 && old.Size != cur.Size)
+	if stale {
+		slog.InfoContext(ctx, "cached spk is stale", "file", cached, "etag", cur.ETag, "last_modified", cur.LastModified, "size", cur.Size)
+	}
+	return stale
+}
+
+func removeCache(cached string) {
+	os.Remove(cached)
+	os.Remove(cached + ".validator")
+}
+
+// fetchToCache 下载到 cached.part，支持断点续传，完成后重命名为 cached。
+// 续传时用 If-Range 携带上次的 ETag，上游文件变化时服务器返回完整内容重新下载。
+func fetchToCache(ctx context.Context, spkUrl, cached string, o options) (err error) {
+	slog.InfoContext(ctx, "download spk", "url", spkUrl, "cache", cached)
+	defer func() {
+		if err != nil {
+			slog.ErrorContext(ctx, "download spk fail", "url", spkUrl, "err", errcheck(err))
+		} else {
+			slog.InfoContext(ctx, "download spk done", "url", spkUrl)
+		}
+	}()
+
+	if err = os.MkdirAll(filepath.Dir(cached), 0o777); err != nil {
+		return
+	}
+
+	part, etagFile := cached+".part", cached+".etag"
+	var offset int64
+	etag, _ := os.ReadFile(etagFile)
+	if stat, e := os.Stat(part); e == nil && len(etag) > 0 {
+		offset = stat.Size()
+	}
+
+	req, err := newRequest(ctx, http.MethodGet, spkUrl)
+	if err != nil {
+		return
+	}
+	if offset > 0 {
+		req.Header.Set("Range", "bytes="+strconv.FormatInt(offset, 10)+"-")
+		req.Header.Set("If-Range", strings.TrimSpace(string(etag)))
+	}
+
+	resp, err := httpClient().Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	flag := os.O_WRONLY | os.O_CREATE
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		slog.InfoContext(ctx, "resume spk download", "offset", units.HumanBytes(offset))
+		flag |= os.O_APPEND
+	case http.StatusOK:
+		offset = 0
+		flag |= os.O_TRUNC
+		if etag := resp.Header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			err = os.WriteFile(etagFile, []byte(etag), 0o666)
+		} else {
+			err = os.Remove(etagFile)
+		}
+		if err != nil && !os.IsNotExist(err) {
+			return
+		}
+		if err = writeValidator(part, validatorOf(resp.Header, resp.ContentLength)); err != nil {
+			return
+		}
+	case http.StatusRequestedRangeNotSatisfiable:
+		// 已下载完整，但没来得及重命名
+		resp.Body.Close()
+		return finishCache(part, etagFile, cached)
+	default:
+		return fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+
+	f, err := os.OpenFile(part, flag, 0o666)
+	if err != nil {
+		return
+	}
+	t := o.track(ctx, spkUrl, offset, resp.ContentLength)
+	_, err = io.Copy(f, io.TeeReader(resp.Body, t))
+	t.Finish(err)
+	if err == nil {
+		err = f.Sync()
+	}
+	if ce := f.Close(); err == nil {
+		err = ce
+	}
+	if err != nil {
+		return
+	}
+	return finishCache(part, etagFile, cached)
+}
+
+// writeValidator 记录完整响应的头，下载完成时随文件一起重命名，续传时沿用首次下载时记录的值
+func writeValidator(part string, v cacheValidator) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(part+".validator", data, 0o666)
+}
+
+func finishCache(part, etagFile, cached string) error {
+	os.Remove(etagFile)
+	if err := os.Rename(part, cached); err != nil {
+		return err
+	}
+	if err := os.Rename(part+".validator", cached+".validator"); os.IsNotExist(err) {
+		os.Remove(cached + ".validator")
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,21 @@
+package spk
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCachedFile(t *testing.T) {
+	a := CachedFile("/cache", "https://mirror.example.com/spk/pan-xunlei-com.spk?sig=a&exp=1")
+	b := CachedFile("/cache", "https://mirror.example.com/spk/pan-xunlei-com.spk?sig=b&exp=2")
+	if filepath.Dir(a) != "/cache" || !strings.HasSuffix(a, "-pan-xunlei-com.spk") || strings.Contains(a, "?") {
+		t.Fatalf("CachedFile = %s", a)
+	}
+	if a == b {
+		t.Fatalf("urls differing only in query share the cache file %s", a)
+	}
+	if c := CachedFile("/cache", "https://mirror.example.com/spk/pan-xunlei-com.spk?sig=a&exp=1"); c != a {
+		t.Fatalf("CachedFile is not stable: %s != %s", c, a)
+	}
+}
@@ -0,0 +1,60 @@
+package spk
+
+import (
+	"path"
+	"strings"
+)
+
+// Filter 选择从 package.tgz 中提取的文件。
+// 迅雷运行必需的文件总是提取并使用固定权限；其余文件在 Full 或匹配 Include 时提取，
+// 匹配 Exclude 的除外，并沿用 tar 头中的权限、修改时间和符号链接。
+//
+// 模式为 path.Match 格式，匹配完整路径或其任一上级目录；不含 / 的模式只匹配文件名，
+// 以 /** 结尾时匹配该目录下的所有文件。
+type Filter struct {
+	Full    bool     `json:"full,omitempty"`
+	Include []string `json:"include,omitempty"`
+	Exclude []string `json:"exclude,omitempty"`
+}
+
+// WithFilter 设置提取的文件范围
+func WithFilter(f Filter) Option { return func(o *options) { o.filter = f } }
+
+// Match name 是否需要提取，required 表示迅雷运行必需的文件
+func (f Filter) Match(name string, required bool) bool {
+	name = strings.TrimPrefix(path.Clean(name), "./")
+	switch {
+	case required:
+		return true
+	case matchAny(f.Exclude, name):
+		return false
+	default:
+		return f.Full || matchAny(f.Include, name)
+	}
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if matchGlob(pattern, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchGlob(pattern, name string) bool {
+	pattern = strings.TrimPrefix(path.Clean(pattern), "./")
+	if dir, ok := strings.CutSuffix(pattern, "/**"); ok {
+		return name == dir || strings.HasPrefix(name, dir+"/")
+	}
+	for p := name; p != "." && p != "/"; p = path.Dir(p) {
+		subject := p
+		if !strings.Contains(pattern, "/") {
+			subject = path.Base(p)
+		}
+		if ok, _ := path.Match(pattern, subject); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,137 @@
+package spk
+
+import (
+	"archive/tar"
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"xlpdok/pkg/httpx"
+)
+
+// Info SPK 包中 INFO 文件的键值
+type Info map[string]string
+
+func (info Info) Version() string { return info["version"] }
+
+// ParseInfo 解析 INFO 文件，每行形如 key="value"
+func ParseInfo(r io.Reader) (info Info, err error) {
+	info = Info{}
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		k, v, ok := strings.Cut(strings.TrimSpace(s.Text()), "=")
+		if !ok || k == "" || strings.HasPrefix(k, "#") {
+			continue
+		}
+		if u, e := strconv.Unquote(v); e == nil {
+			v = u
+		}
+		info[k] = strings.Trim(v, `"`)
+	}
+	return info, s.Err()
+}
+
+var errFound = errors.New("found")
+
+// ReadInfo 从 SPK 流中读取 INFO，找到后立即停止读取
+func ReadInfo(ctx context.Context, src io.Reader) (info Info, err error) {
+	err = Walk(ctx, src, func(r io.Reader, h *tar.Header) (err error) {
+		if h.Name == "INFO" {
+			if info, err = ParseInfo(r); err == nil {
+				err = errFound
+			}
+		}
+		return
+	})
+	if errors.Is(err, errFound) {
+		return info, nil
+	}
+	if err == nil || errors.Is(err, io.ErrUnexpectedEOF) {
+		err = fmt.Errorf("INFO not found")
+	}
+	return
+}
+
+// INFO 位于 SPK 的开头，只需要下载前面一小段
+const infoRange = 256 * 1024
+
+// 读取远程 INFO 的请求超时，ctx 没有更早的截止时间时生效
+const infoTimeout = time.Minute
+
+// RemoteInfo 读取远程 SPK 的 INFO，http(s) 地址通过 Range 请求只下载开头部分
+func RemoteInfo(ctx context.Context, spkUrl string) (info Info, err error) {
+	switch url := strings.ToLower(spkUrl); {
+	case strings.HasPrefix(url, "dir://"):
+		// 已解压的目录没有 INFO，只能读取版本文件
+		if version := readVersionFile(strings.TrimPrefix(spkUrl, "dir://")); version != "" {
+			return Info{"version": version}, nil
+		}
+		return nil, fmt.Errorf("version not found: %s", spkUrl)
+	case spkUrl == Stdin:
+		return nil, fmt.Errorf("stdin can only be read once")
+	case !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://"):
+		f, e := open(ctx, spkUrl)
+		if e != nil {
+			return nil, e
+		}
+		defer f.Close()
+		return ReadInfo(ctx, f)
+	}
+
+	req, err := newRequest(ctx, http.MethodGet, spkUrl)
+	if err != nil {
+		return
+	}
+	req.Header.Set("Range", "bytes=0-"+strconv.Itoa(infoRange-1))
+
+	resp, err := httpx.Client(infoTimeout).Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		return nil, fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	slog.DebugContext(ctx, "remote spk", "url", spkUrl, "status", resp.StatusCode, "etag", resp.Header.Get("ETag"), "last_modified", resp.Header.Get("Last-Modified"))
+
+	// 服务器不支持 Range 时也只读取开头部分
+	return ReadInfo(ctx, io.LimitReader(resp.Body, infoRange))
+}
+
+// CompareVersion 比较版本号中的数字部分，a<b 返回-1，a==b 返回0，a>b 返回1
+func CompareVersion(a, b string) int {
+	split := func(s string) (nums []int) {
+		for f := range strings.FieldsFuncSeq(s, func(r rune) bool { return !unicode.IsDigit(r) }) {
+			n, _ := strconv.Atoi(f)
+			nums = append(nums, n)
+		}
+		return
+	}
+
+	na, nb := split(a), split(b)
+	for i := range max(len(na), len(nb)) {
+		var x, y int
+		if i < len(na) {
+			x = na[i]
+		}
+		if i < len(nb) {
+			y = nb[i]
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
@@ -0,0 +1,151 @@
+package spk
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Entry SPK 中的一个文件
+type Entry struct {
+	Name    string    `json:"name"`
+	Type    string    `json:"type"`
+	Mode    string    `json:"mode"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	Link    string    `json:"link,omitempty"`
+}
+
+// Report SPK 的检查结果
+type Report struct {
+	Source    string    `json:"source"`
+	Size      int64     `json:"size"`
+	Sha256    string    `json:"sha256"`
+	Info      Info      `json:"info"`
+	Signature Signature `json:"signature"`
+	Files     []Entry   `json:"files"`   // SPK 外层 tar 中的文件
+	Package   []Entry   `json:"package"` // package.tgz 中的文件
+	Extract   []string  `json:"extract"` // xlpdok 会提取的文件
+}
+
+// Inspect 读取本地文件或远程地址的 SPK，列出 INFO、文件清单、签名校验结果以及按 WithFilter 会被提取的文件
+func Inspect(ctx context.Context, spkUrl string, opts ...Option) (r Report, err error) {
+	o := newOptions(opts)
+	sv, err := newSigVerifier(o)
+	if err != nil {
+		return
+	}
+
+	src, err := open(ctx, spkUrl)
+	if err != nil {
+		return
+	}
+	defer src.Close()
+
+	h := sha256.New()
+	cr := &counter{r: io.TeeReader(src, h)}
+	r = Report{Source: spkUrl}
+	err = Walk(ctx, cr, func(tr io.Reader, hdr *tar.Header) (err error) {
+		r.Files = append(r.Files, newEntry(hdr))
+		mr := sv.member(hdr.Name, tr)
+		switch hdr.Name {
+		case "INFO":
+			r.Info, err = ParseInfo(mr)
+		case "package.tgz":
+			err = Walk(ctx, mr, func(_ io.Reader, hdr *tar.Header) error {
+				r.Package = append(r.Package, newEntry(hdr))
+				if _, required := extractPerm(hdr.Name); o.filter.Match(hdr.Name, required) && hdr.Typeflag != tar.TypeDir {
+					r.Extract = append(r.Extract, hdr.Name)
+				}
+				return nil
+			})
+		}
+		if err == nil {
+			_, err = io.Copy(io.Discard, mr)
+		}
+		return
+	})
+	if err != nil {
+		return
+	}
+
+	if _, err = io.Copy(io.Discard, cr); err != nil {
+		return
+	}
+	r.Size, r.Sha256 = cr.n, hex.EncodeToString(h.Sum(nil))
+	r.Signature = sv.verify()
+	return
+}
+
+// open 打开 SPK，支持 http(s)、oci、file 地址、本地路径和 - 标准输入
+func open(ctx context.Context, spkUrl string) (io.ReadCloser, error) {
+	switch url := strings.ToLower(spkUrl); {
+	case spkUrl == Stdin:
+		return io.NopCloser(os.Stdin), nil
+	case strings.HasPrefix(url, "oci://") || strings.HasPrefix(url, "oci+http://"):
+		rc, _, err := openOCI(ctx, spkUrl)
+		return rc, err
+	case strings.HasPrefix(url, "dir://"):
+		return nil, fmt.Errorf("not an spk file: %s", spkUrl)
+	case strings.HasPrefix(url, "http://") || strings.HasPrefix(url, "https://"):
+		req, err := newRequest(ctx, http.MethodGet, spkUrl)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := httpClient().Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("unexpected status: %s", resp.Status)
+		}
+		return resp.Body, nil
+	default:
+		return os.Open(strings.TrimPrefix(spkUrl, "file://"))
+	}
+}
+
+func newEntry(h *tar.Header) Entry {
+	return Entry{
+		Name:    h.Name,
+		Type:    entryType(h.Typeflag),
+		Mode:    h.FileInfo().Mode().String(),
+		Size:    h.Size,
+		ModTime: h.ModTime,
+		Link:    h.Linkname,
+	}
+}
+
+func entryType(flag byte) string {
+	switch flag {
+	case tar.TypeReg:
+		return "file"
+	case tar.TypeDir:
+		return "dir"
+	case tar.TypeSymlink:
+		return "symlink"
+	case tar.TypeLink:
+		return "hardlink"
+	default:
+		return string(flag)
+	}
+}
+
+type counter struct {
+	r io.Reader
+	n int64
+}
+
+func (c *counter) Read(p []byte) (n int, err error) {
+	n, err = c.r.Read(p)
+	c.n += int64(n)
+	return
+}
@@ -0,0 +1,204 @@
+package spk
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ManifestFile 完整性清单的文件名，位于解压目录中
+const ManifestFile = ".manifest.json"
+
+var ErrNoManifest = errors.New("spk manifest not found")
+
+// Manifest 解压结果的完整性清单
+type Manifest struct {
+	Version   string     `json:"version"`
+	Sha256    string     `json:"sha256"` // SPK 文件的 SHA-256
+	Signature *Signature `json:"signature,omitempty"`
+	Files     []FileSum  `json:"files"`
+}
+
+// FileSum 清单中的一个文件
+type FileSum struct {
+	Path    string      `json:"path"`
+	Size    int64       `json:"size"`
+	Sha256  string      `json:"sha256,omitempty"`
+	Mode    fs.FileMode `json:"mode"`
+	ModTime time.Time   `json:"mod_time"`
+	Link    string      `json:"link,omitempty"`
+}
+
+// ReadManifest 读取 dir 中的完整性清单
+func ReadManifest(dir string) (m Manifest, err error) {
+	data, err := os.ReadFile(filepath.Join(dir, ManifestFile))
+	if os.IsNotExist(err) {
+		err = ErrNoManifest
+	}
+	if err == nil {
+		err = json.Unmarshal(data, &m)
+	}
+	return
+}
+
+// writeManifest 记录 dir 中所有文件和链接的大小、权限、修改时间和 SHA-256
+func writeManifest(dir, spkSha256 string, sig *Signature) (err error) {
+	m := Manifest{Version: readVersionFile(dir), Sha256: spkSha256, Signature: sig}
+	err = filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, p)
+		if rel == ManifestFile {
+			return nil
+		}
+		sum, err := fileSum(p, true)
+		sum.Path = filepath.ToSlash(rel)
+		m.Files = append(m.Files, sum)
+		return err
+	})
+	if err != nil {
+		return
+	}
+
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return
+	}
+	f, err := os.OpenFile(filepath.Join(dir, ManifestFile), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return
+	}
+	if _, err = f.Write(data); err == nil {
+		err = f.Sync()
+	}
+	if ce := f.Close(); err == nil {
+		err = ce
+	}
+	return
+}
+
+func fileSum(p string, deep bool) (sum FileSum, err error) {
+	stat, err := os.Lstat(p)
+	if err != nil {
+		return
+	}
+	sum = FileSum{Size: stat.Size(), Mode: stat.Mode(), ModTime: stat.ModTime()}
+
+	switch {
+	case stat.Mode()&fs.ModeSymlink != 0:
+		sum.Link, err = os.Readlink(p)
+	case stat.Mode().IsRegular() && deep:
+		var f *os.File
+		if f, err = os.Open(p); err != nil {
+			return
+		}
+		defer f.Close()
+		h := sha256.New()
+		if _, err = io.Copy(h, f); err == nil {
+			sum.Sha256 = hex.EncodeToString(h.Sum(nil))
+		}
+	}
+	return
+}
+
+// Check 按清单检查 dir 中的文件，返回缺失或被改动的文件。
+// 快速模式只比较类型、大小和修改时间，deep 时重新计算 SHA-256。没有清单时返回 ErrNoManifest
+func Check(ctx context.Context, dir string, deep bool) (bad []string, err error) {
+	m, err := ReadManifest(dir)
+	if err != nil {
+		return
+	}
+
+	for _, want := range m.Files {
+		if err = ctx.Err(); err != nil {
+			return
+		}
+
+		got, e := fileSum(filepath.Join(dir, filepath.FromSlash(want.Path)), deep && want.Sha256 != "")
+		switch {
+		case e != nil:
+		case got.Mode.Type() != want.Mode.Type():
+			e = fmt.Errorf("type changed: %s", got.Mode.Type())
+		case got.Link != want.Link:
+			e = fmt.Errorf("link changed: %s", got.Link)
+		case got.Mode.IsRegular() && got.Size != want.Size:
+			e = fmt.Errorf("size changed: %d", got.Size)
+		case got.Mode.IsRegular() && !got.ModTime.Equal(want.ModTime):
+			e = fmt.Errorf("mtime changed: %s", got.ModTime)
+		case got.Sha256 != "" && got.Sha256 != want.Sha256:
+			e = fmt.Errorf("sha256 changed: %s", got.Sha256)
+		default:
+			continue
+		}
+
+		slog.WarnContext(ctx, "spk check fail", "file", want.Path, "err", errcheck(e))
+		bad = append(bad, want.Path)
+	}
+	return
+}
+
+// Repair 从 spkFile 重新解压，替换 dir 中损坏的文件。spkFile 必须与清单中记录的 SPK 一致
+func Repair(ctx context.Context, dir, spkFile string, bad []string, filter Filter) (err error) {
+	m, err := ReadManifest(dir)
+	if err != nil {
+		return
+	}
+	if dir, err = filepath.EvalSymlinks(dir); err != nil {
+		return
+	}
+
+	f, err := os.Open(spkFile)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	staging, err := os.MkdirTemp(filepath.Dir(dir), "."+filepath.Base(dir)+"-repair-")
+	if err != nil {
+		return
+	}
+	defer os.RemoveAll(staging)
+
+	if err = extractSpk(ctx, f, staging, options{filter: filter}); err != nil {
+		return
+	}
+	fresh, err := ReadManifest(staging)
+	if err != nil {
+		return
+	}
+	if fresh.Sha256 != m.Sha256 {
+		return fmt.Errorf("%w: %s is %s, installed from %s", ErrDigestMismatch, spkFile, fresh.Sha256, m.Sha256)
+	}
+
+	for _, name := range bad {
+		src, e := safeJoin(staging, name)
+		if err = e; err != nil {
+			return
+		}
+		target, e := safeJoin(dir, name)
+		if err = e; err != nil {
+			return
+		}
+		if err = os.MkdirAll(filepath.Dir(target), 0o777); err != nil {
+			return
+		}
+		if err = os.Rename(src, target); err != nil {
+			return
+		}
+		if err = syncDir(filepath.Dir(target)); err != nil {
+			return
+		}
+		slog.InfoContext(ctx, "spk repair", "file", name, "from", spkFile)
+	}
+	return
+}
@@ -0,0 +1,72 @@
+package spk
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+const maxBackoff = 30 * time.Second
+
+// WithRetry 每个镜像最多尝试 attempts 次(默认3)，每次尝试的超时为 timeout(默认10分钟)
+func WithRetry(attempts int, timeout time.Duration) Option {
+	return func(o *options) { o.attempts, o.attemptTimeout = attempts, timeout }
+}
+
+// DownloadFrom 按顺序从镜像列表下载，每个镜像失败后按指数退避重试，重试用尽后换下一个镜像。
+// 支持 http、https、file、oci 地址，dir:// 已解压的包目录，以及 - 标准输入。
+func DownloadFrom(ctx context.Context, spkUrls []string, dir string, force bool, opts ...Option) (err error) {
+	if len(spkUrls) == 0 {
+		return fmt.Errorf("spk url is empty")
+	}
+
+	if !force && allExists(ctx, dir) {
+		slog.InfoContext(ctx, "check spk all spk file exists")
+		return
+	}
+
+	o := newOptions(opts)
+	attempts := cmp.Or(o.attempts, 3)
+	var errs []error
+	for i, spkUrl := range spkUrls {
+		// 标准输入只能读一次
+		attempts := attempts
+		if spkUrl == Stdin {
+			attempts = 1
+		}
+		for attempt := 1; attempt <= attempts; attempt++ {
+			if err = downloadAttempt(ctx, spkUrl, dir, o); err == nil {
+				return
+			}
+			errs = append(errs, fmt.Errorf("%s attempt %d: %w", spkUrl, attempt, err))
+
+			if ctx.Err() != nil {
+				return errors.Join(errs...)
+			}
+
+			// 摘要不匹配说明该镜像内容不对，重试无意义
+			if errors.Is(err, ErrDigestMismatch) || attempt == attempts {
+				slog.WarnContext(ctx, "spk mirror fail", "mirror", i, "url", spkUrl, "attempt", attempt, "err", errcheck(err))
+				break
+			}
+
+			backoff := min(time.Second<<(attempt-1), maxBackoff)
+			slog.WarnContext(ctx, "spk download retry", "mirror", i, "url", spkUrl, "attempt", attempt, "backoff", backoff, "err", errcheck(err))
+			select {
+			case <-ctx.Done():
+				return errors.Join(append(errs, ctx.Err())...)
+			case <-time.After(backoff):
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func downloadAttempt(ctx context.Context, spkUrl, dir string, o options) error {
+	ctx, cancel := context.WithTimeout(ctx, cmp.Or(o.attemptTimeout, 10*time.Minute))
+	defer cancel()
+	return download(ctx, spkUrl, dir, o)
+}
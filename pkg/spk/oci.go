@@ -0,0 +1,269 @@
+package spk
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// OCI 制品中 SPK 层的识别方式：注解中的文件名以 .spk 结尾，或者制品只有一层
+const (
+	ociAnnotationTitle = "org.opencontainers.image.title"
+	ociManifestTypes   = "application/vnd.oci.image.manifest.v1+json, application/vnd.docker.distribution.manifest.v2+json"
+)
+
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+type ociManifest struct {
+	MediaType string          `json:"mediaType"`
+	Layers    []ociDescriptor `json:"layers"`
+}
+
+// ociRef 解析 oci://[user:pass@]host[:port]/repo[:tag|@digest]，tag 默认 latest。
+// 本机地址和 oci+http:// 使用 http，其余使用 https
+type ociRef struct {
+	base      string // scheme://host
+	repo      string
+	reference string
+	user      *url.Userinfo
+	token     string // 仓库要求 Bearer 认证时获取的令牌
+}
+
+func parseOCI(spkUrl string) (ref ociRef, err error) {
+	scheme := "https"
+	rest, ok := strings.CutPrefix(spkUrl, "oci+http://")
+	if ok {
+		scheme = "http"
+	} else if rest, ok = strings.CutPrefix(spkUrl, "oci://"); !ok {
+		return ref, fmt.Errorf("not an oci url: %s", spkUrl)
+	}
+
+	u, err := url.Parse("//" + rest)
+	if err != nil {
+		return
+	}
+	if host := u.Hostname(); host == "localhost" || net.ParseIP(host).IsLoopback() {
+		scheme = "http"
+	}
+
+	repo := strings.Trim(u.Path, "/")
+	reference := "latest"
+	if i := strings.LastIndex(repo, "@"); i > 0 {
+		repo, reference = repo[:i], repo[i+1:]
+	} else if i := strings.LastIndex(repo, ":"); i > strings.LastIndex(repo, "/") {
+		repo, reference = repo[:i], repo[i+1:]
+	}
+	if u.Host == "" || repo == "" {
+		return ref, fmt.Errorf("invalid oci url: %s", spkUrl)
+	}
+	return ociRef{base: scheme + "://" + u.Host, repo: repo, reference: reference, user: u.User}, nil
+}
+
+// get 请求仓库的 /v2/<repo><p>。仓库返回 401 且要求 Bearer 认证时，按 WWW-Authenticate 获取令牌后重试一次
+func (ref *ociRef) get(ctx context.Context, p, accept string) (resp *http.Response, err error) {
+	for retried := false; ; retried = true {
+		req, e := http.NewRequestWithContext(ctx, http.MethodGet, ref.base+"/v2/"+ref.repo+p, nil)
+		if e != nil {
+			return nil, e
+		}
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		if ref.token != "" {
+			req.Header.Set("Authorization", "Bearer "+ref.token)
+		} else if ref.user != nil {
+			pass, _ := ref.user.Password()
+			req.SetBasicAuth(ref.user.Username(), pass)
+		}
+		if resp, err = httpClient().Do(req); err != nil {
+			return
+		}
+
+		if resp.StatusCode == http.StatusUnauthorized && !retried {
+			challenge := resp.Header.Get("WWW-Authenticate")
+			resp.Body.Close()
+			if scheme, params := parseChallenge(challenge); strings.EqualFold(scheme, "bearer") {
+				if ref.token, err = ref.fetchToken(ctx, params); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			return nil, fmt.Errorf("oci %s: unexpected status: %s", p, resp.Status)
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("oci %s: unexpected status: %s", p, resp.Status)
+		}
+		return
+	}
+}
+
+// fetchToken 按 Docker Registry 令牌认证流程从 realm 获取拉取令牌，配置了用户名密码时以 Basic 认证请求
+func (ref *ociRef) fetchToken(ctx context.Context, params map[string]string) (token string, err error) {
+	realm, err := url.Parse(params["realm"])
+	if err != nil || realm.Host == "" {
+		return "", fmt.Errorf("oci auth: invalid realm: %q", params["realm"])
+	}
+	q := realm.Query()
+	if service := params["service"]; service != "" {
+		q.Set("service", service)
+	}
+	q.Set("scope", cmp.Or(params["scope"], "repository:"+ref.repo+":pull"))
+	realm.RawQuery = q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return
+	}
+	if ref.user != nil {
+		pass, _ := ref.user.Password()
+		req.SetBasicAuth(ref.user.Username(), pass)
+	}
+	resp, err := httpClient().Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("oci auth: unexpected status: %s", resp.Status)
+	}
+
+	var body struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err = json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&body); err != nil {
+		return "", fmt.Errorf("oci auth: %w", err)
+	}
+	if token = cmp.Or(body.Token, body.AccessToken); token == "" {
+		return "", errors.New("oci auth: empty token")
+	}
+	return
+}
+
+// parseChallenge 解析 WWW-Authenticate，如 Bearer realm="https://auth/token",service="registry",scope="repository:a:pull"
+func parseChallenge(h string) (scheme string, params map[string]string) {
+	scheme, rest, _ := strings.Cut(strings.TrimSpace(h), " ")
+	params = map[string]string{}
+	for rest = strings.TrimSpace(rest); rest != ""; {
+		key, value, ok := strings.Cut(rest, "=")
+		if !ok {
+			break
+		}
+		key, value = strings.ToLower(strings.TrimSpace(key)), strings.TrimSpace(value)
+		if strings.HasPrefix(value, `"`) {
+			end := strings.Index(value[1:], `"`)
+			if end < 0 {
+				break
+			}
+			params[key], rest = value[1:end+1], value[end+2:]
+		} else {
+			params[key], rest, _ = strings.Cut(value, ",")
+			params[key] = strings.TrimSpace(params[key])
+		}
+		rest = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(rest), ","))
+	}
+	return
+}
+
+// openOCI 从 OCI 仓库拉取 SPK 制品，返回 SPK 层的内容和长度，读到末尾时校验层摘要
+func openOCI(ctx context.Context, spkUrl string) (rc io.ReadCloser, size int64, err error) {
+	ref, err := parseOCI(spkUrl)
+	if err != nil {
+		return
+	}
+
+	resp, err := ref.get(ctx, "/manifests/"+ref.reference, ociManifestTypes)
+	if err != nil {
+		return
+	}
+	var m ociManifest
+	err = json.NewDecoder(io.LimitReader(resp.Body, 4<<20)).Decode(&m)
+	resp.Body.Close()
+	if err != nil {
+		return nil, 0, fmt.Errorf("oci manifest: %w", err)
+	}
+
+	layer, err := m.spkLayer()
+	if err != nil {
+		return
+	}
+	algo, want, _ := strings.Cut(layer.Digest, ":")
+	if algo != "sha256" {
+		return nil, 0, fmt.Errorf("oci layer digest not supported: %s", layer.Digest)
+	}
+
+	if resp, err = ref.get(ctx, "/blobs/"+layer.Digest, ""); err != nil {
+		return
+	}
+	return &digestReader{ReadCloser: resp.Body, h: sha256.New(), want: want}, layer.Size, nil
+}
+
+func (m ociManifest) spkLayer() (ociDescriptor, error) {
+	for _, l := range m.Layers {
+		if strings.HasSuffix(strings.ToLower(l.Annotations[ociAnnotationTitle]), ".spk") {
+			return l, nil
+		}
+	}
+	if len(m.Layers) == 1 {
+		return m.Layers[0], nil
+	}
+	return ociDescriptor{}, fmt.Errorf("oci artifact has %d layers and none is titled *.spk", len(m.Layers))
+}
+
+// digestReader 读到末尾时校验内容的 SHA-256
+type digestReader struct {
+	io.ReadCloser
+	h    hash.Hash
+	want string
+}
+
+func (d *digestReader) Read(p []byte) (n int, err error) {
+	n, err = d.ReadCloser.Read(p)
+	d.h.Write(p[:n])
+	if errors.Is(err, io.EOF) {
+		if got := hex.EncodeToString(d.h.Sum(nil)); got != d.want {
+			err = fmt.Errorf("%w: oci layer want %s, got %s", ErrDigestMismatch, d.want, got)
+		}
+	}
+	return
+}
+
+func download_oci(ctx context.Context, spkUrl string, dir string, o options) (err error) {
+	slog.InfoContext(ctx, "download spk", "url", spkUrl)
+	defer func() {
+		if err != nil {
+			slog.ErrorContext(ctx, "download spk fail", "url", spkUrl, "err", errcheck(err))
+		} else {
+			slog.InfoContext(ctx, "download spk done", "url", spkUrl)
+		}
+	}()
+
+	rc, size, err := openOCI(ctx, spkUrl)
+	if err != nil {
+		return
+	}
+	defer rc.Close()
+
+	t := o.track(ctx, spkUrl, 0, size)
+	err = extract(ctx, io.TeeReader(rc, t), dir, spkUrl, o)
+	t.Finish(err)
+	return
+}
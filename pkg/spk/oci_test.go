@@ -0,0 +1,180 @@
+package spk
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// registry 模拟 OCI 仓库：/v2/ 下的请求需要 /token 签发的 Bearer 令牌
+type registry struct {
+	*httptest.Server
+	repo, tag string
+	layers    []ociDescriptor
+	blobs     map[string][]byte
+	user      string // 令牌接口要求的 Basic 认证用户名，为空不要求
+	tokens    atomic.Int32
+}
+
+func newRegistry(t *testing.T, repo, tag string) *registry {
+	r := &registry{repo: repo, tag: tag, blobs: map[string][]byte{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /token", func(w http.ResponseWriter, req *http.Request) {
+		if user, _, _ := req.BasicAuth(); user != r.user {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if req.URL.Query().Get("service") != "test-registry" || req.URL.Query().Get("scope") != "repository:"+repo+":pull" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		r.tokens.Add(1)
+		json.NewEncoder(w).Encode(map[string]string{"token": "secret-token"})
+	})
+	mux.HandleFunc("GET /v2/", func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Authorization") != "Bearer secret-token" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="`+r.URL+`/token",service="test-registry",scope="repository:`+repo+`:pull"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		switch p := strings.TrimPrefix(req.URL.Path, "/v2/"+repo); {
+		case p == "/manifests/"+tag:
+			w.Header().Set("Content-Type", "application/vnd.oci.image.manifest.v1+json")
+			json.NewEncoder(w).Encode(ociManifest{MediaType: "application/vnd.oci.image.manifest.v1+json", Layers: r.layers})
+		case strings.HasPrefix(p, "/blobs/"):
+			data, find := r.blobs[strings.TrimPrefix(p, "/blobs/")]
+			if !find {
+				http.NotFound(w, req)
+				return
+			}
+			w.Write(data)
+		default:
+			http.NotFound(w, req)
+		}
+	})
+	r.Server = httptest.NewServer(mux)
+	t.Cleanup(r.Close)
+	return r
+}
+
+// push 加入一层，title 为空时不带文件名注解
+func (r *registry) push(data []byte, title string) {
+	d := ociDescriptor{MediaType: "application/octet-stream", Digest: "sha256:" + sha256Hex(data), Size: int64(len(data))}
+	if title != "" {
+		d.Annotations = map[string]string{ociAnnotationTitle: title}
+	}
+	r.layers = append(r.layers, d)
+	r.blobs[d.Digest] = data
+}
+
+func (r *registry) ref(user string) string {
+	host := strings.TrimPrefix(r.URL, "http://")
+	if user != "" {
+		host = user + "@" + host
+	}
+	return "oci://" + host + "/" + r.repo + ":" + r.tag
+}
+
+func TestOCI(t *testing.T) {
+	data := makeSpk(t, "3.21.0")
+	reg := newRegistry(t, "xunlei/spk", "v3")
+	reg.push([]byte(`{"readme":true}`), "README.json")
+	reg.push(data, "pan-xunlei-com.spk")
+
+	dst := filepath.Join(t.TempDir(), "dst")
+	if err := Download(context.Background(), reg.ref(""), dst, true, WithSha256(sha256Hex(data))); err != nil {
+		t.Fatal(err)
+	}
+	if v := readVersion(t, dst); v != "3.21.0" {
+		t.Fatalf("version = %s", v)
+	}
+	// 清单和层共用一次获取的令牌
+	if n := reg.tokens.Load(); n != 1 {
+		t.Errorf("tokens issued = %d, want 1", n)
+	}
+
+	if info, err := RemoteInfo(context.Background(), reg.ref("")); err != nil || info.Version() != "3.21.0" {
+		t.Fatalf("RemoteInfo = %v, %v", info, err)
+	}
+}
+
+func TestOCITokenWithCredentials(t *testing.T) {
+	reg := newRegistry(t, "spk", "latest")
+	reg.user = "robot"
+	reg.push(makeSpk(t, "3.21.0"), "")
+
+	if err := Download(context.Background(), reg.ref(""), filepath.Join(t.TempDir(), "dst"), true); err == nil {
+		t.Fatal("token request without credentials should fail")
+	}
+	if err := Download(context.Background(), reg.ref("robot:pass"), filepath.Join(t.TempDir(), "dst"), true); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOCILayerDigest(t *testing.T) {
+	reg := newRegistry(t, "spk", "latest")
+	reg.push(makeSpk(t, "3.21.0"), "pan-xunlei-com.spk")
+	reg.blobs[reg.layers[0].Digest] = makeSpk(t, "3.99.0")
+
+	err := Download(context.Background(), reg.ref(""), filepath.Join(t.TempDir(), "dst"), true)
+	if !errors.Is(err, ErrDigestMismatch) {
+		t.Fatalf("err = %v, want ErrDigestMismatch", err)
+	}
+}
+
+func TestOCIAmbiguousLayers(t *testing.T) {
+	reg := newRegistry(t, "spk", "latest")
+	reg.push([]byte("a"), "a.bin")
+	reg.push([]byte("b"), "b.bin")
+
+	err := Download(context.Background(), reg.ref(""), filepath.Join(t.TempDir(), "dst"), true)
+	if err == nil || !strings.Contains(err.Error(), "none is titled") {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+func TestParseOCI(t *testing.T) {
+	tests := []struct {
+		url                   string
+		base, repo, reference string
+	}{
+		{"oci://ghcr.io/a/b", "https://ghcr.io", "a/b", "latest"},
+		{"oci://ghcr.io/a/b:3.21", "https://ghcr.io", "a/b", "3.21"},
+		{"oci://ghcr.io/a/b@sha256:00", "https://ghcr.io", "a/b", "sha256:00"},
+		{"oci://localhost:5000/b:1", "http://localhost:5000", "b", "1"},
+		{"oci://127.0.0.1:5000/b", "http://127.0.0.1:5000", "b", "latest"},
+		{"oci+http://registry.lan:5000/a/b:2", "http://registry.lan:5000", "a/b", "2"},
+		{"oci://u:p@registry.lan/a", "https://registry.lan", "a", "latest"},
+	}
+	for _, tt := range tests {
+		ref, err := parseOCI(tt.url)
+		if err != nil || ref.base != tt.base || ref.repo != tt.repo || ref.reference != tt.reference {
+			t.Errorf("parseOCI(%s) = %+v, %v", tt.url, ref, err)
+		}
+	}
+
+	for _, u := range []string{"oci://", "oci://host", "http://host/a"} {
+		if _, err := parseOCI(u); err == nil {
+			t.Errorf("parseOCI(%s) should fail", u)
+		}
+	}
+}
+
+func TestParseChallenge(t *testing.T) {
+	scheme, params := parseChallenge(`Bearer realm="https://auth.example.com/token",service="registry.example.com",scope="repository:a/b:pull,push"`)
+	if scheme != "Bearer" || params["realm"] != "https://auth.example.com/token" || params["service"] != "registry.example.com" || params["scope"] != "repository:a/b:pull,push" {
+		t.Fatalf("scheme=%s params=%v", scheme, params)
+	}
+
+	scheme, params = parseChallenge(`Basic realm=registry`)
+	if scheme != "Basic" || params["realm"] != "registry" {
+		t.Fatalf("scheme=%s params=%v", scheme, params)
+	}
+}
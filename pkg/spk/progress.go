@@ -0,0 +1,159 @@
+package spk
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"xlpdok/pkg/units"
+)
+
+// Progress 下载进度
+type Progress struct {
+	Url     string        `json:"url"`
+	Current int64         `json:"current"`
+	Total   int64         `json:"total"` // 服务器没有返回长度时为 -1
+	Rate    float64       `json:"rate"`  // 字节/秒
+	ETA     time.Duration `json:"eta"`   // 未知时为 0
+	Done    bool          `json:"done"`
+	Err     string        `json:"err,omitempty"`
+}
+
+// Percent 完成百分比，总长度未知时返回 -1
+func (p Progress) Percent() float64 {
+	if p.Total <= 0 {
+		return -1
+	}
+	return float64(p.Current) * 100 / float64(p.Total)
+}
+
+// Reporter 接收下载进度，未完成时最多每 progressInterval 调用一次，完成或失败时总会调用一次
+type Reporter func(ctx context.Context, p Progress)
+
+const progressInterval = 500 * time.Millisecond
+
+// WithProgress 设置下载进度的输出，未设置时使用 LogProgress
+func WithProgress(reporters ...Reporter) Option {
+	return func(o *options) { o.reporters = append(o.reporters, reporters...) }
+}
+
+// tracker 统计写入的字节数并按间隔上报进度
+type tracker struct {
+	ctx       context.Context
+	reporters []Reporter
+
+	mu      sync.Mutex
+	p       Progress
+	start   time.Time
+	resumed int64
+	last    time.Time
+}
+
+// track 开始跟踪 spkUrl 的下载，current 为已下载(续传)的字节数，total 为剩余长度，未知时为 -1
+func (o options) track(ctx context.Context, spkUrl string, current, total int64) *tracker {
+	if total >= 0 {
+		total += current
+	}
+	reporters := o.reporters
+	if len(reporters) == 0 {
+		reporters = []Reporter{LogProgress(10 * time.Second)}
+	}
+	return &tracker{
+		ctx:       ctx,
+		reporters: reporters,
+		p:         Progress{Url: spkUrl, Current: current, Total: total},
+		start:     time.Now(),
+		resumed:   current,
+	}
+}
+
+func (t *tracker) Write(b []byte) (n int, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.p.Current += int64(len(b))
+	if now := time.Now(); now.Sub(t.last) >= progressInterval {
+		t.last = now
+		t.report()
+	}
+	return len(b), nil
+}
+
+// Finish 上报最终结果
+func (t *tracker) Finish(err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.p.Done = true
+	if err != nil {
+		t.p.Err = err.Error()
+	}
+	t.report()
+}
+
+func (t *tracker) report() {
+	if elapsed := time.Since(t.start).Seconds(); elapsed > 0 {
+		t.p.Rate = float64(t.p.Current-t.resumed) / elapsed
+	}
+	t.p.ETA = 0
+	if t.p.Total > 0 && t.p.Rate > 0 && !t.p.Done {
+		t.p.ETA = time.Duration(float64(t.p.Total-t.p.Current) / t.p.Rate * float64(time.Second)).Round(time.Second)
+	}
+	for _, r := range t.reporters {
+		r(t.ctx, t.p)
+	}
+}
+
+// LogProgress 每隔 interval 输出一行带速度和剩余时间的日志，适合非终端环境
+func LogProgress(interval time.Duration) Reporter {
+	var last time.Time
+	return func(ctx context.Context, p Progress) {
+		if !p.Done && time.Since(last) < interval {
+			return
+		}
+		last = time.Now()
+
+		attrs := []any{"url", p.Url, "current", units.HumanBytes(p.Current), "rate", units.HumanBytes(int64(p.Rate)) + "/s"}
+		if p.Total > 0 {
+			attrs = append(attrs, "total", units.HumanBytes(p.Total), "percent", fmt.Sprintf("%.1f%%", p.Percent()))
+		}
+		switch {
+		case p.Err != "":
+			slog.WarnContext(ctx, "spk download interrupted", append(attrs, "err", p.Err)...)
+		case p.Done:
+			slog.InfoContext(ctx, "spk download finished", attrs...)
+		default:
+			if p.ETA > 0 {
+				attrs = append(attrs, "eta", p.ETA)
+			}
+			slog.InfoContext(ctx, "spk download progress", attrs...)
+		}
+	}
+}
+
+// BarProgress 在终端中输出单行进度条
+func BarProgress(w io.Writer) Reporter {
+	const width = 30
+	return func(ctx context.Context, p Progress) {
+		var line strings.Builder
+		fmt.Fprintf(&line, "\r%s ", path.Base(p.Url))
+		if percent := p.Percent(); percent >= 0 {
+			filled := min(int(percent*width/100), width)
+			fmt.Fprintf(&line, "[%s%s] %5.1f%% %s/%s", strings.Repeat("=", filled), strings.Repeat(" ", width-filled), percent, units.HumanBytes(p.Current), units.HumanBytes(p.Total))
+		} else {
+			fmt.Fprintf(&line, "%s", units.HumanBytes(p.Current))
+		}
+		fmt.Fprintf(&line, " %s/s", units.HumanBytes(int64(p.Rate)))
+		if p.ETA > 0 {
+			fmt.Fprintf(&line, " ETA %s", p.ETA)
+		}
+		line.WriteString("\033[K")
+		if p.Done {
+			line.WriteString("\n")
+		}
+		io.WriteString(w, line.String())
+	}
+}
@@ -0,0 +1,229 @@
+package spk
+
+import (
+	"bytes"
+	"crypto"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"embed"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	pgperrors "github.com/ProtonMail/go-crypto/openpgp/errors"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+)
+
+// SignatureFile 群晖 SPK 中的签名文件
+const SignatureFile = "syno_signature.asc"
+
+// 签名校验状态
+const (
+	SignatureValid     = "valid"     // 签名有效
+	SignatureInvalid   = "invalid"   // 签名无效、被篡改或签名者不在公钥环中
+	SignatureUnsigned  = "unsigned"  // 没有签名文件
+	SignatureUnchecked = "unchecked" // 有签名文件，但没有可用的公钥环
+)
+
+var ErrSignature = errors.New("spk signature verification failed")
+
+// Signature SPK 签名的校验结果
+type Signature struct {
+	Status string `json:"status"`
+	Signer string `json:"signer,omitempty"`
+	KeyId  string `json:"key_id,omitempty"`
+	Err    string `json:"err,omitempty"`
+}
+
+func (s Signature) String() string {
+	switch {
+	case s.Signer != "":
+		return s.Status + " (" + s.Signer + ", " + s.KeyId + ")"
+	case s.Err != "":
+		return s.Status + ": " + s.Err
+	default:
+		return s.Status
+	}
+}
+
+// WithSignature 用公钥环(armored 或二进制)校验 syno_signature.asc，keyringFiles 为空时使用内置的群晖公钥(见 DefaultKeyring)。
+// strict 时拒绝没有签名或签名无效的 SPK，否则只记录结果
+func WithSignature(keyringFiles []string, strict bool) Option {
+	return func(o *options) { o.keyringFiles, o.strict = keyringFiles, strict }
+}
+
+// 签名覆盖 SPK 中除签名文件外所有文件的内容，按归档中的顺序依次拼接。
+// 签名文件通常在归档末尾，读到之前不知道签名使用的摘要算法，用 TeeReader 边读边同时计算常用的摘要
+type sigVerifier struct {
+	keyring openpgp.EntityList
+	hashes  map[crypto.Hash]hash.Hash // 未配置公钥环时不计算
+	sig     []byte
+	found   bool
+}
+
+// signatureHashes 签名可能使用的摘要算法
+var signatureHashes = []crypto.Hash{crypto.SHA256, crypto.SHA512, crypto.SHA384, crypto.SHA224}
+
+func newSigVerifier(o options) (v *sigVerifier, err error) {
+	v = &sigVerifier{}
+	if len(o.keyringFiles) > 0 {
+		if v.keyring, err = loadKeyring(o.keyringFiles); err != nil {
+			return nil, err
+		}
+	} else {
+		v.keyring = DefaultKeyring()
+	}
+	if o.strict && len(v.keyring) == 0 {
+		return nil, fmt.Errorf("%w: strict mode needs a keyring", ErrSignature)
+	}
+	if len(v.keyring) > 0 {
+		v.hashes = map[crypto.Hash]hash.Hash{}
+		for _, h := range signatureHashes {
+			v.hashes[h] = h.New()
+		}
+	}
+	return
+}
+
+// member 返回读取外层文件 name 时使用的 reader
+func (v *sigVerifier) member(name string, r io.Reader) io.Reader {
+	switch {
+	case name == SignatureFile:
+		v.found = true
+		v.sig, _ = io.ReadAll(io.LimitReader(r, 1<<20))
+		return bytes.NewReader(v.sig)
+	case v.hashes != nil:
+		ws := make([]io.Writer, 0, len(v.hashes))
+		for _, h := range v.hashes {
+			ws = append(ws, h)
+		}
+		return io.TeeReader(r, io.MultiWriter(ws...))
+	default:
+		return r
+	}
+}
+
+func (v *sigVerifier) verify() (s Signature) {
+	switch {
+	case !v.found:
+		return Signature{Status: SignatureUnsigned}
+	case v.hashes == nil:
+		return Signature{Status: SignatureUnchecked}
+	}
+
+	signer, err := v.check()
+	if err != nil {
+		return Signature{Status: SignatureInvalid, Err: err.Error()}
+	}
+
+	s = Signature{Status: SignatureValid, KeyId: signer.PrimaryKey.KeyIdString()}
+	for name := range signer.Identities {
+		s.Signer = name
+		break
+	}
+	return
+}
+
+// check 用读取时计算好的摘要校验分离签名，与 openpgp.CheckDetachedSignature 的校验相同，但不需要再读一遍内容
+func (v *sigVerifier) check() (signer *openpgp.Entity, err error) {
+	var r io.Reader = bytes.NewReader(v.sig)
+	if bytes.Contains(v.sig, []byte("-----BEGIN PGP SIGNATURE-----")) {
+		block, err := armor.Decode(r)
+		if err != nil {
+			return nil, err
+		}
+		r = block.Body
+	}
+
+	p, err := packet.Read(r)
+	if err != nil {
+		return
+	}
+	sig, ok := p.(*packet.Signature)
+	switch {
+	case !ok:
+		return nil, errors.New("not a signature packet")
+	case sig.SigType != packet.SigTypeBinary:
+		return nil, fmt.Errorf("unexpected signature type %d", sig.SigType)
+	case sig.IssuerKeyId == nil:
+		return nil, errors.New("signature doesn't have an issuer")
+	case len(sig.Salt()) > 0:
+		return nil, fmt.Errorf("unsupported signature version %d", sig.Version)
+	}
+
+	h, find := v.hashes[sig.Hash]
+	if !find {
+		return nil, fmt.Errorf("unsupported signature hash %s", sig.Hash)
+	}
+	keys := v.keyring.KeysByIdUsage(*sig.IssuerKeyId, packet.KeyFlagSign)
+	if len(keys) == 0 {
+		return nil, pgperrors.ErrUnknownIssuer
+	}
+
+	// 摘要只能结束一次，同一个 KeyId 对应多个公钥几乎不可能，只用第一个
+	key := keys[0]
+	if err = key.PublicKey.VerifySignature(h, sig); err != nil {
+		return
+	}
+	now := time.Now()
+	if sig.SigExpired(now) {
+		return nil, pgperrors.ErrSignatureExpired
+	}
+	if key.Revoked(now) || key.Entity.Revoked(now) {
+		return nil, pgperrors.ErrKeyRevoked
+	}
+	return key.Entity, nil
+}
+
+//go:embed keyring
+var embedKeyring embed.FS
+
+// DefaultKeyring 编译时嵌入的群晖签名公钥(见 keyring 目录)，未配置公钥环时使用，没有内置公钥时为空
+var DefaultKeyring = sync.OnceValue(func() (keyring openpgp.EntityList) {
+	entries, _ := embedKeyring.ReadDir("keyring")
+	for _, entry := range entries {
+		if ext := path.Ext(entry.Name()); ext != ".asc" && ext != ".gpg" {
+			continue
+		}
+		data, err := embedKeyring.ReadFile("keyring/" + entry.Name())
+		if err == nil {
+			var keys openpgp.EntityList
+			if keys, err = parseKeyring(data); err == nil {
+				keyring = append(keyring, keys...)
+				continue
+			}
+		}
+		slog.Warn("read embedded keyring", "file", entry.Name(), "err", err)
+	}
+	return
+})
+
+func loadKeyring(files []string) (keyring openpgp.EntityList, err error) {
+	for _, file := range files {
+		data, e := os.ReadFile(file)
+		if e != nil {
+			return nil, e
+		}
+		keys, e := parseKeyring(data)
+		if e != nil {
+			return nil, fmt.Errorf("read keyring %s: %w", file, e)
+		}
+		keyring = append(keyring, keys...)
+	}
+	return
+}
+
+func parseKeyring(data []byte) (openpgp.EntityList, error) {
+	if bytes.Contains(data, []byte("-----BEGIN PGP")) {
+		return openpgp.ReadArmoredKeyRing(bytes.NewReader(data))
+	}
+	return openpgp.ReadKeyRing(bytes.NewReader(data))
+}
@@ -0,0 +1,84 @@
+package spk
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+
+	"xlpdok/pkg/tartest"
+)
+
+// signedSpk 生成带 syno_signature.asc 的 SPK，签名覆盖签名文件之前所有文件的内容，tamper 在签名后修改 INFO
+func signedSpk(t *testing.T, key *openpgp.Entity, tamper bool) []byte {
+	t.Helper()
+	info := "package=\"pan-xunlei-com\"\nversion=\"3.21.0\"\n"
+	pkg := string(tartest.Gzip(t, tartest.Tar(t, tartest.File("bin/bin/version", "3.21.0\n"))))
+
+	var sig bytes.Buffer
+	if err := openpgp.ArmoredDetachSign(&sig, key, bytes.NewReader([]byte(info+pkg)), nil); err != nil {
+		t.Fatal(err)
+	}
+	if tamper {
+		info += "evil=1\n"
+	}
+	return tartest.Tar(t, tartest.File("INFO", info), tartest.File("package.tgz", pkg), tartest.File(SignatureFile, sig.String()))
+}
+
+func writeKeyring(t *testing.T, key *openpgp.Entity) string {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), "keyring.gpg")
+	f, err := os.Create(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err = key.Serialize(f); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestSignature(t *testing.T) {
+	ctx := context.Background()
+	key, err := openpgp.NewEntity("test", "", "test@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyring := []string{writeKeyring(t, key)}
+
+	dst := t.TempDir()
+	if err = Extract(ctx, bytes.NewReader(signedSpk(t, key, false)), dst, WithSignature(keyring, true)); err != nil {
+		t.Fatal(err)
+	}
+	m, err := ReadManifest(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Signature == nil || m.Signature.Status != SignatureValid || m.Signature.KeyId != key.PrimaryKey.KeyIdString() {
+		t.Fatalf("signature = %+v, want valid", m.Signature)
+	}
+
+	err = Extract(ctx, bytes.NewReader(signedSpk(t, key, true)), t.TempDir(), WithSignature(keyring, true))
+	if !errors.Is(err, ErrSignature) {
+		t.Fatalf("tampered spk: err = %v, want ErrSignature", err)
+	}
+
+	// 非严格模式只记录结果
+	dst = t.TempDir()
+	if err = Extract(ctx, bytes.NewReader(signedSpk(t, key, true)), dst, WithSignature(keyring, false)); err != nil {
+		t.Fatal(err)
+	}
+	if m, err = ReadManifest(dst); err != nil || m.Signature.Status != SignatureInvalid {
+		t.Fatalf("signature = %+v, %v, want invalid", m.Signature, err)
+	}
+
+	err = Extract(ctx, bytes.NewReader(makeSpk(t, "3.21.0")), t.TempDir(), WithSignature(keyring, true))
+	if !errors.Is(err, ErrSignature) {
+		t.Fatalf("unsigned spk: err = %v, want ErrSignature", err)
+	}
+}
@@ -0,0 +1,138 @@
+package spk
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Stdin 从标准输入读取 SPK 的地址
+const Stdin = "-"
+
+func download_stdin(ctx context.Context, dir string, o options) (err error) {
+	slog.InfoContext(ctx, "download spk", "url", "stdin")
+	defer func() {
+		if err != nil {
+			slog.ErrorContext(ctx, "download spk fail", "url", "stdin", "err", errcheck(err))
+		} else {
+			slog.InfoContext(ctx, "download spk done", "url", "stdin")
+		}
+	}()
+
+	t := o.track(ctx, "stdin", 0, -1)
+	err = extract(ctx, io.TeeReader(os.Stdin, t), dir, Stdin, o)
+	t.Finish(err)
+	return
+}
+
+// download_dir 从已解压的包目录(package.tgz 的内容)复制。
+// 目录中有完整性清单时先完整校验，复制后的清单沿用其中的 SPK 摘要
+func download_dir(ctx context.Context, spkUrl string, dir string, o options) (err error) {
+	src := strings.TrimPrefix(spkUrl, "dir://")
+	slog.InfoContext(ctx, "copy spk", "dir", src)
+	defer func() {
+		if err != nil {
+			slog.ErrorContext(ctx, "copy spk fail", "dir", src, "err", errcheck(err))
+		} else {
+			slog.InfoContext(ctx, "copy spk done", "dir", src)
+		}
+	}()
+
+	var spkSha256 string
+	var sig *Signature
+	switch m, e := ReadManifest(src); {
+	case e == nil:
+		bad, e := Check(ctx, src, true)
+		if err = e; err != nil {
+			return
+		}
+		if len(bad) > 0 {
+			return fmt.Errorf("%s fails its manifest: %s", src, strings.Join(bad, ", "))
+		}
+		spkSha256, sig = m.Sha256, m.Signature
+	case e != ErrNoManifest:
+		return e
+	}
+
+	// 目录中无法重新校验签名，只能沿用清单中记录的结果
+	if o.strict && (sig == nil || sig.Status != SignatureValid) {
+		return fmt.Errorf("%w: %s has no valid signature record", ErrSignature, src)
+	}
+
+	want, err := o.expectedDigest(ctx, spkUrl)
+	if err != nil {
+		return
+	}
+	if want != "" && want != spkSha256 {
+		return fmt.Errorf("%w: want %s, manifest of %s has %q", ErrDigestMismatch, want, src, spkSha256)
+	}
+
+	if err = os.MkdirAll(filepath.Dir(dir), 0o777); err != nil {
+		return
+	}
+	staging, err := os.MkdirTemp(filepath.Dir(dir), "."+filepath.Base(dir)+"-staging-")
+	if err != nil {
+		return
+	}
+	defer os.RemoveAll(staging)
+
+	if err = copyTree(ctx, src, staging, o.filter); err != nil {
+		return
+	}
+	if err = writeManifest(staging, spkSha256, sig); err != nil {
+		return
+	}
+	return swapDir(staging, dir)
+}
+
+// copyTree 按 filter 复制包目录，保留权限、修改时间和符号链接
+func copyTree(ctx context.Context, src, dst string, filter Filter) error {
+	return filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+
+		rel, _ := filepath.Rel(src, p)
+		name := filepath.ToSlash(rel)
+		perm, required := extractPerm(name)
+		if name == ManifestFile || !filter.Match(name, required) {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		var link string
+		if info.Mode()&fs.ModeSymlink != 0 {
+			if link, err = os.Readlink(p); err != nil {
+				return err
+			}
+		}
+		h, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		h.Name = name
+
+		var r io.Reader = strings.NewReader("")
+		if info.Mode().IsRegular() {
+			f, err := os.Open(p)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			r = f
+		}
+		return extractEntry(dst, r, h, perm)
+	})
+}
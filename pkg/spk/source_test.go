@@ -0,0 +1,108 @@
+package spk
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+)
+
+func readVersion(t *testing.T, dir string) string {
+	t.Helper()
+	v := readVersionFile(dir)
+	if v == "" {
+		t.Fatalf("no version in %s", dir)
+	}
+	return v
+}
+
+func TestStdin(t *testing.T) {
+	data := makeSpk(t, "3.21.0")
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() { w.Write(data); w.Close() }()
+
+	stdin := os.Stdin
+	os.Stdin = r
+	t.Cleanup(func() { os.Stdin = stdin; r.Close() })
+
+	dst := filepath.Join(t.TempDir(), "dst")
+	if err = Download(context.Background(), Stdin, dst, true, WithSha256(sha256Hex(data))); err != nil {
+		t.Fatal(err)
+	}
+	if v := readVersion(t, dst); v != "3.21.0" {
+		t.Fatalf("version = %s", v)
+	}
+
+	m, err := ReadManifest(dst)
+	if err != nil || m.Sha256 != sha256Hex(data) {
+		t.Fatalf("manifest sha256 = %q, %v", m.Sha256, err)
+	}
+}
+
+func TestStdinInfo(t *testing.T) {
+	// 标准输入只能读取一次，不能为了 INFO 预先读取
+	if _, err := RemoteInfo(context.Background(), Stdin); err == nil {
+		t.Fatal("RemoteInfo of stdin should fail")
+	}
+}
+
+func TestDir(t *testing.T) {
+	ctx := context.Background()
+	data := makeSpk(t, "3.21.0")
+	src := filepath.Join(t.TempDir(), "src")
+	if err := Download(ctx, "file://"+writeSpk(t, data), src, true); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := filepath.Join(t.TempDir(), "dst")
+	if err := Download(ctx, "dir://"+src, dst, true, WithSha256(sha256Hex(data))); err != nil {
+		t.Fatal(err)
+	}
+	if v := readVersion(t, dst); v != "3.21.0" {
+		t.Fatalf("version = %s", v)
+	}
+	if bad, err := Check(ctx, dst, true); err != nil || len(bad) > 0 {
+		t.Fatalf("check copied dir: bad=%v err=%v", bad, err)
+	}
+	if info, err := RemoteInfo(ctx, "dir://"+src); err != nil || info.Version() != "3.21.0" {
+		t.Fatalf("RemoteInfo = %v, %v", info, err)
+	}
+
+	// 清单中的摘要与期望不一致
+	err := Download(ctx, "dir://"+src, filepath.Join(t.TempDir(), "dst"), true, WithSha256(sha256Hex([]byte("other"))))
+	if !errors.Is(err, ErrDigestMismatch) {
+		t.Fatalf("err = %v, want ErrDigestMismatch", err)
+	}
+
+	// 源目录被篡改后不满足自己的清单
+	if err = os.WriteFile(filepath.Join(src, "ui/index.cgi"), []byte("#!/bin/sh\nevil\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err = Download(ctx, "dir://"+src, filepath.Join(t.TempDir(), "dst"), true); err == nil {
+		t.Fatal("tampered source dir should be rejected")
+	}
+}
+
+func TestDirStrict(t *testing.T) {
+	ctx := context.Background()
+	src := filepath.Join(t.TempDir(), "src")
+	if err := Download(ctx, "file://"+writeSpk(t, makeSpk(t, "3.21.0")), src, true); err != nil {
+		t.Fatal(err)
+	}
+
+	// 公钥环只需有效，不需要与 SPK 对应：目录中没有可用的签名记录
+	key, err := openpgp.NewEntity("test", "", "test@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Download(ctx, "dir://"+src, filepath.Join(t.TempDir(), "dst"), true, WithSignature([]string{writeKeyring(t, key)}, true))
+	if !errors.Is(err, ErrSignature) {
+		t.Fatalf("err = %v, want ErrSignature", err)
+	}
+}
@@ -2,73 +2,208 @@ package spk
 
 import (
 	"archive/tar"
+	"bufio"
+	"bytes"
 	"cmp"
+	"compress/bzip2"
+	"compress/gzip"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"io"
 	"io/fs"
 	"log/slog"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 
+	"github.com/klauspost/compress/zstd"
 	"github.com/ulikunitz/xz"
 )
 
-// Extract 从迅雷SPK中提取需要的文件(存在则跳过)
-func Extract(ctx context.Context, src io.Reader, dstDir string) (err error) {
+// Extract 从迅雷SPK中提取需要的文件，WithFilter 决定提取的范围，WithSignature 校验群晖签名。
+// 完成后在 dstDir 中写入完整性清单(见 Manifest)，其中记录整个 SPK 的 SHA-256 和签名校验结果
+func Extract(ctx context.Context, src io.Reader, dstDir string, opts ...Option) (err error) {
+	return extractSpk(ctx, src, dstDir, newOptions(opts))
+}
+
+func extractSpk(ctx context.Context, src io.Reader, dstDir string, o options) (err error) {
+	sv, err := newSigVerifier(o)
+	if err != nil {
+		return
+	}
+
+	h := sha256.New()
+	tee := io.TeeReader(src, h)
+	if err = extractPackage(ctx, tee, dstDir, o.filter, sv); err != nil {
+		return
+	}
+	// tar 读取器可能没有读到文件末尾，剩余部分也要计入摘要
+	if _, err = io.Copy(io.Discard, tee); err != nil {
+		return
+	}
+
+	sig := sv.verify()
+	if sig.Status == SignatureInvalid || sig.Status == SignatureUnsigned && o.strict {
+		slog.WarnContext(ctx, "spk signature", "status", sig.Status, "err", sig.Err)
+		if o.strict {
+			return fmt.Errorf("%w: %s", ErrSignature, sig)
+		}
+	} else {
+		slog.InfoContext(ctx, "spk signature", "status", sig.Status, "signer", sig.Signer, "key_id", sig.KeyId)
+	}
+	return writeManifest(dstDir, hex.EncodeToString(h.Sum(nil)), &sig)
+}
+
+// extractPackage 遍历 SPK 的所有文件，解压 package.tgz，其余文件只计入签名
+func extractPackage(ctx context.Context, src io.Reader, dstDir string, filter Filter, sv *sigVerifier) (err error) {
 	return Walk(ctx, src, func(tr io.Reader, h *tar.Header) (err error) {
+		r := sv.member(h.Name, tr)
 		if h.Name == "package.tgz" {
-			err = cmp.Or(Walk(ctx, tr, func(tr io.Reader, h *tar.Header) (err error) {
-				var perm fs.FileMode
-				switch {
-				case strings.HasPrefix(h.Name, "bin/bin/version"):
-					perm = 0o666
-				case strings.HasPrefix(h.Name, "bin/bin/xunlei-pan-cli"):
-					perm = 0o777
-				case h.Name == "ui/index.cgi":
-					perm = 0o777
-				default:
+			err = Walk(ctx, r, func(tr io.Reader, h *tar.Header) (err error) {
+				perm, required := extractPerm(h.Name)
+				if !filter.Match(h.Name, required) {
 					return
 				}
 
-				err = func() (err error) {
-					target := filepath.Join(dstDir, h.Name)
-					if err = os.MkdirAll(filepath.Dir(target), 0o777); err != nil {
-						return
-					}
-
-					f, e := os.OpenFile(target, os.O_RDWR|os.O_CREATE|os.O_TRUNC, perm)
-					if e != nil {
-						if !os.IsExist(e) {
-							err = e
-						}
-						return
-					}
-					_, err = io.Copy(f, tr)
-					if ce := f.Close(); err == nil && ce != nil {
-						err = ce
-					}
-					return
-				}()
-
-				if err != nil {
-					slog.WarnContext(ctx, "extract package", "perm", perm, "target_dir", dstDir, "name", h.Name, "err", err)
+				if err = extractEntry(dstDir, tr, h, perm); err != nil {
+					slog.WarnContext(ctx, "extract package", "type", entryType(h.Typeflag), "target_dir", dstDir, "name", h.Name, "err", err)
 				} else {
-					slog.DebugContext(ctx, "extract package", "perm", perm, "target_dir", dstDir, "name", h.Name)
+					slog.DebugContext(ctx, "extract package", "type", entryType(h.Typeflag), "target_dir", dstDir, "name", h.Name)
 				}
 
 				return
-			}, Xz), io.EOF)
+			})
+		}
+		if err == nil {
+			_, err = io.Copy(io.Discard, r)
 		}
 		return
 	})
 }
 
+// extractEntry 写入一个 tar 条目。perm 不为 0 时使用固定权限，否则沿用 tar 头中的权限
+func extractEntry(dstDir string, r io.Reader, h *tar.Header, perm fs.FileMode) (err error) {
+	target, err := safeJoin(dstDir, h.Name)
+	if err != nil {
+		return
+	}
+	mode := h.FileInfo().Mode().Perm()
+
+	switch h.Typeflag {
+	case tar.TypeDir:
+		if err = os.MkdirAll(target, 0o777); err == nil {
+			err = os.Chmod(target, mode|0o700)
+		}
+		return
+	case tar.TypeSymlink:
+		if err = os.MkdirAll(filepath.Dir(target), 0o777); err != nil {
+			return
+		}
+		// 链接目标也必须位于 dstDir 内
+		if _, e := safeJoin(dstDir, filepath.Join(filepath.Dir(h.Name), h.Linkname)); e != nil || filepath.IsAbs(h.Linkname) {
+			return fmt.Errorf("%w: %s -> %s", ErrUnsafeEntry, h.Name, h.Linkname)
+		}
+		if err = os.RemoveAll(target); err != nil {
+			return
+		}
+		return os.Symlink(h.Linkname, target)
+	case tar.TypeLink:
+		if err = os.MkdirAll(filepath.Dir(target), 0o777); err != nil {
+			return
+		}
+		source, e := safeJoin(dstDir, h.Linkname)
+		if err = e; err != nil {
+			return
+		}
+		if err = os.RemoveAll(target); err != nil {
+			return
+		}
+		return os.Link(source, target)
+	case tar.TypeReg:
+	default:
+		return
+	}
+
+	if err = os.MkdirAll(filepath.Dir(target), 0o777); err != nil {
+		return
+	}
+
+	f, err := os.OpenFile(target, os.O_RDWR|os.O_CREATE|os.O_TRUNC, cmp.Or(perm, mode))
+	if err != nil {
+		return
+	}
+	if _, err = io.Copy(f, r); err == nil {
+		err = f.Sync()
+	}
+	if ce := f.Close(); err == nil && ce != nil {
+		err = ce
+	}
+	if err == nil && perm == 0 {
+		err = os.Chmod(target, mode)
+	}
+	if err == nil && !h.ModTime.IsZero() {
+		err = os.Chtimes(target, h.ModTime, h.ModTime)
+	}
+	return
+}
+
+// extractPerm package.tgz 中需要提取的文件及其权限
+func extractPerm(name string) (perm fs.FileMode, ok bool) {
+	switch {
+	case strings.HasPrefix(name, "bin/bin/version"):
+		return 0o666, true
+	case strings.HasPrefix(name, "bin/bin/xunlei-pan-cli"):
+		return 0o777, true
+	case name == "ui/index.cgi":
+		return 0o777, true
+	}
+	return
+}
+
+// ErrUnsafeEntry 条目或链接目标解析到目标目录之外
+var ErrUnsafeEntry = errors.New("spk entry escapes target dir")
+
+// safeJoin 拼接 dstDir 与条目名，解析到 dstDir 之外，或者经过已解压的符号链接时返回错误
+func safeJoin(dstDir, name string) (string, error) {
+	target := filepath.Join(dstDir, name)
+	rel, err := filepath.Rel(dstDir, target)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", fmt.Errorf("%w: %s", ErrUnsafeEntry, name)
+	}
+
+	// 链接目标只做了字面检查，多级链接可能绕出 dstDir，所以不允许经由链接写入
+	for dir := filepath.Dir(rel); dir != "."; dir = filepath.Dir(dir) {
+		if stat, e := os.Lstat(filepath.Join(dstDir, dir)); e == nil && stat.Mode()&fs.ModeSymlink != 0 {
+			return "", fmt.Errorf("%w: %s (via symlink %s)", ErrUnsafeEntry, name, dir)
+		}
+	}
+	return target, nil
+}
+
+// syncDir 将目录项的变更(创建、重命名)落盘
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
 /* tar decode functions */
 
+// Walk 遍历 tar 流。未指定 decoder 时按魔数自动识别压缩格式，见 Detect
 func Walk(ctx context.Context, src io.Reader, walk WalkFunc, decoder ...Decoder) (err error) {
 	dr := io.NopCloser(src)
 
+	if !slices.ContainsFunc(decoder, func(d Decoder) bool { return d != nil }) {
+		decoder = []Decoder{Detect}
+	}
+
 	for _, d := range decoder {
 		if d != nil {
 			if dr, err = d(dr); err != nil {
@@ -94,11 +229,45 @@ func Walk(ctx context.Context, src io.Reader, walk WalkFunc, decoder ...Decoder)
 	return
 }
 
+// Detect 按魔数选择解码器，支持 gzip、bzip2、zstd、xz，都不匹配时按未压缩的 tar 处理
+func Detect(src io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(src)
+	magic, _ := br.Peek(6)
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		return Gzip(br)
+	case bytes.HasPrefix(magic, []byte("BZh")):
+		return Bzip2(br)
+	case bytes.HasPrefix(magic, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		return Zstd(br)
+	case bytes.HasPrefix(magic, []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}):
+		return Xz(br)
+	default:
+		return io.NopCloser(br), nil
+	}
+}
+
+func Gzip(src io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(src)
+}
+
 func Xz(src io.Reader) (io.ReadCloser, error) {
 	xzr, err := xz.NewReader(src)
 	return io.NopCloser(xzr), err
 }
 
+func Bzip2(src io.Reader) (io.ReadCloser, error) {
+	return io.NopCloser(bzip2.NewReader(src)), nil
+}
+
+func Zstd(src io.Reader) (io.ReadCloser, error) {
+	zr, err := zstd.NewReader(src)
+	if err != nil {
+		return nil, err
+	}
+	return zr.IOReadCloser(), nil
+}
+
 type Decoder func(io.Reader) (io.ReadCloser, error)
 
 type WalkFunc func(r io.Reader, h *tar.Header) (err error)
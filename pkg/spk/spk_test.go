@@ -0,0 +1,115 @@
+package spk
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"xlpdok/pkg/tartest"
+)
+
+// makeSpk 生成最小的 SPK：INFO 和包含运行必需文件的 package.tgz，extra 追加到 package.tgz 中
+func makeSpk(t *testing.T, version string, extra ...tartest.Entry) []byte {
+	t.Helper()
+	pkg := append([]tartest.Entry{
+		tartest.File("bin/bin/version", version+"\n"),
+		tartest.File("bin/bin/xunlei-pan-cli-launcher."+runtime.GOARCH, "launcher"),
+		tartest.File("bin/bin/xunlei-pan-cli."+version+"."+runtime.GOARCH, "cli"),
+		tartest.File("ui/index.cgi", "#!/bin/sh\n"),
+	}, extra...)
+	return tartest.Tar(t,
+		tartest.File("INFO", "package=\"pan-xunlei-com\"\nversion=\""+version+"\"\n"),
+		tartest.File("package.tgz", string(tartest.Gzip(t, tartest.Tar(t, pkg...)))),
+	)
+}
+
+func writeSpk(t *testing.T, data []byte) string {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), "pan-xunlei-com.spk")
+	if err := os.WriteFile(p, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func TestSafeJoin(t *testing.T) {
+	dst := t.TempDir()
+	for _, name := range []string{"a", "a/b/c", "./a", "a/../b", "/abs/path"} {
+		if got, err := safeJoin(dst, name); err != nil || !filepath.IsAbs(got) {
+			t.Errorf("safeJoin(%q) = %q, %v", name, got, err)
+		}
+	}
+	for _, name := range []string{"..", "../a", "a/../../b", "a/../.."} {
+		if _, err := safeJoin(dst, name); !errors.Is(err, ErrUnsafeEntry) {
+			t.Errorf("safeJoin(%q) err = %v, want ErrUnsafeEntry", name, err)
+		}
+	}
+
+	// 经过已存在的符号链接写入会被拒绝，即使链接本身指向目录内
+	if err := os.Mkdir(filepath.Join(dst, "real"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("real", filepath.Join(dst, "link")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := safeJoin(dst, "link/file"); !errors.Is(err, ErrUnsafeEntry) {
+		t.Errorf("safeJoin via symlink err = %v, want ErrUnsafeEntry", err)
+	}
+	if _, err := safeJoin(dst, "link"); err != nil {
+		t.Errorf("safeJoin of the symlink itself: %v", err)
+	}
+}
+
+func TestExtractRejectsUnsafeEntries(t *testing.T) {
+	tests := map[string][]tartest.Entry{
+		"traversal":          {tartest.File("ui/../../escape", "x")},
+		"absolute symlink":   {tartest.Symlink("ui/abs", "/etc")},
+		"escaping symlink":   {tartest.Symlink("ui/up", "../../..")},
+		"escaping hardlink":  {tartest.Hardlink("ui/hard", "../../etc/passwd")},
+		"via symlink":        {tartest.Symlink("ui/dir", "."), tartest.File("ui/dir/index.cgi", "x")},
+		"chained symlinks":   {tartest.Symlink("ui/a", "."), tartest.Symlink("ui/a/b", ".."), tartest.File("ui/a/b/x", "x")},
+		"symlink then write": {tartest.Symlink("ui/lnk", "../bin"), tartest.File("ui/lnk/evil", "x")},
+	}
+
+	for name, entries := range tests {
+		t.Run(name, func(t *testing.T) {
+			root := t.TempDir()
+			dst := filepath.Join(root, "dst")
+			src := writeSpk(t, makeSpk(t, "3.21.0", entries...))
+			err := Download(context.Background(), "file://"+src, dst, true, WithFilter(Filter{Full: true}))
+			if !errors.Is(err, ErrUnsafeEntry) {
+				t.Fatalf("err = %v, want ErrUnsafeEntry", err)
+			}
+			if _, e := os.Stat(dst); !os.IsNotExist(e) {
+				t.Errorf("target dir exists after a rejected extract: %v", e)
+			}
+			if left, _ := filepath.Glob(filepath.Join(root, "*")); len(left) > 0 {
+				t.Errorf("files left outside the target: %v", left)
+			}
+		})
+	}
+}
+
+func TestExtractSafeLinks(t *testing.T) {
+	dst := filepath.Join(t.TempDir(), "dst")
+	src := writeSpk(t, makeSpk(t, "3.21.0", tartest.Symlink("ui/index.html", "index.cgi"), tartest.Hardlink("ui/copy.cgi", "ui/index.cgi")))
+	if err := Download(context.Background(), "file://"+src, dst, true, WithFilter(Filter{Full: true})); err != nil {
+		t.Fatal(err)
+	}
+	if link, err := os.Readlink(filepath.Join(dst, "ui/index.html")); err != nil || link != "index.cgi" {
+		t.Errorf("symlink = %q, %v", link, err)
+	}
+	if data, err := os.ReadFile(filepath.Join(dst, "ui/copy.cgi")); err != nil || string(data) != "#!/bin/sh\n" {
+		t.Errorf("hardlink = %q, %v", data, err)
+	}
+}
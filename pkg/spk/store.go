@@ -0,0 +1,241 @@
+package spk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Store 多版本并存的 SPK 安装目录。
+// 每个版本解压到 Dir/<version>，Link 为指向当前版本的符号链接，切换版本时原子替换 Link。
+// Shared 中的子目录(名称 -> 实际路径)在各版本间共享，版本目录中只放指向它的符号链接。
+type Store struct {
+	Dir    string
+	Link   string
+	Shared map[string]string
+}
+
+const previousFile = ".previous"
+
+// Versions 已安装的版本，从新到旧排序
+func (s Store) Versions() (versions []string) {
+	entries, _ := os.ReadDir(s.Dir)
+	for _, e := range entries {
+		if e.IsDir() && !strings.HasPrefix(e.Name(), ".") {
+			versions = append(versions, e.Name())
+		}
+	}
+	slices.SortFunc(versions, func(a, b string) int { return CompareVersion(b, a) })
+	return
+}
+
+// Has 版本是否已安装
+func (s Store) Has(version string) bool {
+	stat, err := os.Stat(filepath.Join(s.Dir, version))
+	return validVersion(version) && err == nil && stat.IsDir()
+}
+
+// Active 当前使用的版本
+func (s Store) Active() string {
+	target, err := os.Readlink(s.Link)
+	if err != nil || filepath.Dir(target) != filepath.Clean(s.Dir) {
+		return ""
+	}
+	return filepath.Base(target)
+}
+
+// Previous 上一次使用的版本
+func (s Store) Previous() string {
+	v, _ := os.ReadFile(filepath.Join(s.Dir, previousFile))
+	return strings.TrimSpace(string(v))
+}
+
+// Migrate 将旧版本直接解压在 Link 位置的目录迁移为版本目录
+func (s Store) Migrate(ctx context.Context) (err error) {
+	stat, err := os.Lstat(s.Link)
+	if err != nil || !stat.IsDir() {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return
+	}
+
+	if err = os.MkdirAll(s.Dir, 0o777); err != nil {
+		return
+	}
+
+	// 先把共享目录移出去，避免随旧版本一起被回收
+	for name, shared := range s.Shared {
+		if _, e := os.Stat(shared); e == nil {
+			continue
+		}
+		if e := os.Rename(filepath.Join(s.Link, name), shared); e != nil && !os.IsNotExist(e) {
+			return e
+		}
+	}
+
+	version := readVersionFile(s.Link)
+	if !validVersion(version) || s.Has(version) {
+		slog.WarnContext(ctx, "spk migrate, discard legacy install", "dir", s.Link, "version", version)
+		if err = os.RemoveAll(s.Link); err != nil || !s.Has(version) {
+			return
+		}
+		return s.Use(ctx, version)
+	}
+
+	slog.InfoContext(ctx, "spk migrate", "dir", s.Link, "version", version)
+	if err = os.Rename(s.Link, filepath.Join(s.Dir, version)); err != nil {
+		return
+	}
+	if err = s.linkShared(filepath.Join(s.Dir, version)); err != nil {
+		return
+	}
+	return s.Use(ctx, version)
+}
+
+// Install 从镜像列表下载 SPK 并解压为新的版本目录，返回安装的版本，不切换当前版本
+func (s Store) Install(ctx context.Context, spkUrls []string, opts ...Option) (version string, err error) {
+	if err = os.MkdirAll(s.Dir, 0o777); err != nil {
+		return
+	}
+
+	staging, err := os.MkdirTemp(s.Dir, ".staging-")
+	if err != nil {
+		return
+	}
+	defer os.RemoveAll(staging)
+
+	if err = DownloadFrom(ctx, spkUrls, staging, true, opts...); err != nil {
+		return
+	}
+
+	if version = readVersionFile(staging); !validVersion(version) {
+		return "", fmt.Errorf("invalid spk version: %q", version)
+	}
+
+	target := filepath.Join(s.Dir, version)
+	if version == s.Active() {
+		// 重装当前版本，先挪开旧目录再放入新目录，两次 rename 之间链接短暂悬空
+		old := filepath.Join(s.Dir, ".old-"+version)
+		if err = os.Rename(target, old); err != nil {
+			return
+		}
+		defer os.RemoveAll(old)
+	} else if err = os.RemoveAll(target); err != nil {
+		return
+	}
+
+	if err = os.Rename(staging, target); err != nil {
+		return
+	}
+	if err = os.Chmod(target, 0o755); err != nil {
+		return
+	}
+	if err = s.linkShared(target); err != nil {
+		return
+	}
+	err = syncDir(s.Dir)
+	return
+}
+
+// Use 原子切换当前版本
+func (s Store) Use(ctx context.Context, version string) (err error) {
+	if !s.Has(version) {
+		return fmt.Errorf("spk version not installed: %s", version)
+	}
+
+	active := s.Active()
+	if active == version {
+		return
+	}
+
+	tmp := s.Link + ".tmp"
+	os.Remove(tmp)
+	if err = os.Symlink(filepath.Join(s.Dir, version), tmp); err != nil {
+		return
+	}
+	if err = os.Rename(tmp, s.Link); err != nil {
+		os.Remove(tmp)
+		return
+	}
+	if err = syncDir(filepath.Dir(s.Link)); err != nil {
+		return
+	}
+
+	if active != "" {
+		err = os.WriteFile(filepath.Join(s.Dir, previousFile), []byte(active+"\n"), 0o666)
+	}
+	slog.InfoContext(ctx, "spk switch", "version", version, "previous", active)
+	return
+}
+
+// Rollback 切换回上一次使用的版本，返回切换后的版本
+func (s Store) Rollback(ctx context.Context) (version string, err error) {
+	if version = s.Previous(); version == "" || !s.Has(version) {
+		return "", errors.New("no previous spk version to roll back to")
+	}
+	return version, s.Use(ctx, version)
+}
+
+// GC 回收旧版本，保留最新的 keep 个，当前版本、上一版本和 protect 中的版本始终保留。keep <= 0 不回收版本。
+// 中断遗留的临时目录总是清理：安装和解压的暂存目录、重装和解压替换时挪开的旧目录、修复时的暂存目录
+func (s Store) GC(ctx context.Context, keep int, protect ...string) {
+	for _, pattern := range []string{".staging-*", ".old-*", ".*-staging-*", ".*-old-*", ".*-repair-*"} {
+		stale, _ := filepath.Glob(filepath.Join(s.Dir, pattern))
+		for _, dir := range stale {
+			if err := os.RemoveAll(dir); err != nil {
+				slog.WarnContext(ctx, "spk gc", "dir", dir, "err", err)
+			}
+		}
+	}
+
+	if keep <= 0 {
+		return
+	}
+
+	protect = append(protect, s.Active(), s.Previous())
+	for i, version := range s.Versions() {
+		if i < keep || slices.Contains(protect, version) {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(s.Dir, version)); err != nil {
+			slog.WarnContext(ctx, "spk gc", "version", version, "err", err)
+		} else {
+			slog.InfoContext(ctx, "spk gc", "version", version)
+		}
+	}
+}
+
+// linkShared 在版本目录中创建指向共享目录的符号链接
+func (s Store) linkShared(dir string) (err error) {
+	for name, shared := range s.Shared {
+		if err = os.MkdirAll(shared, 0o777); err != nil {
+			return
+		}
+		link := filepath.Join(dir, name)
+		if err = os.RemoveAll(link); err != nil {
+			return
+		}
+		if err = os.Symlink(shared, link); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Installed 目录中的 SPK 文件是否完整
+func Installed(ctx context.Context, dir string) bool { return allExists(ctx, dir) }
+
+func readVersionFile(dir string) string {
+	v, _ := os.ReadFile(filepath.Join(dir, "bin/bin/version"))
+	return strings.TrimSpace(string(v))
+}
+
+func validVersion(version string) bool {
+	return version != "" && version != "." && version != ".." && !strings.ContainsAny(version, `/\`) && !strings.HasPrefix(version, ".")
+}
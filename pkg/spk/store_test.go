@@ -0,0 +1,54 @@
+package spk
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestStoreGC(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	s := Store{Dir: filepath.Join(root, "versions"), Link: filepath.Join(root, "target")}
+
+	for _, v := range []string{"3.19.0", "3.20.0", "3.21.0", "3.22.0"} {
+		if err := Download(ctx, "file://"+writeSpk(t, makeSpk(t, v)), filepath.Join(s.Dir, v), true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Use(ctx, "3.19.0"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Use(ctx, "3.20.0"); err != nil {
+		t.Fatal(err)
+	}
+
+	// 中断遗留的临时目录
+	stale := []string{".staging-1", ".old-3.20.0", "..staging-1-staging-2", ".3.20.0-repair-3", "..staging-1-old-4"}
+	for _, name := range stale {
+		if err := os.MkdirAll(filepath.Join(s.Dir, name, "bin"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s.GC(ctx, 0)
+	for _, name := range stale {
+		if _, err := os.Stat(filepath.Join(s.Dir, name)); !os.IsNotExist(err) {
+			t.Errorf("%s should be removed even when keep is 0: %v", name, err)
+		}
+	}
+	if got := s.Versions(); len(got) != 4 {
+		t.Fatalf("keep 0 should not remove versions: %v", got)
+	}
+
+	// 保留最新的1个，当前版本和上一版本始终保留
+	s.GC(ctx, 1)
+	if got, want := s.Versions(), []string{"3.22.0", "3.20.0", "3.19.0"}; !slices.Equal(got, want) {
+		t.Fatalf("versions = %v, want %v", got, want)
+	}
+	if s.Active() != "3.20.0" || s.Previous() != "3.19.0" {
+		t.Fatalf("active=%s previous=%s", s.Active(), s.Previous())
+	}
+}
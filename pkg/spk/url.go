@@ -1,9 +1,7 @@
 package spk
 
 import (
-	"cmp"
 	"context"
-	"crypto/tls"
 	"fmt"
 	"io"
 	"log/slog"
@@ -11,31 +9,43 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
-	"slices"
-	"strconv"
 	"strings"
-	"sync/atomic"
+
+	"xlpdok/pkg/httpx"
+	"xlpdok/pkg/units"
 )
 
 // 检查并下载, 如果 force，忽略检查直接下载
-func Download(ctx context.Context, spkUrl string, dir string, force bool) (err error) {
+func Download(ctx context.Context, spkUrl string, dir string, force bool, opts ...Option) (err error) {
 	if !force && allExists(ctx, dir) {
 		slog.InfoContext(ctx, "check spk all spk file exists")
 		return
 	}
 
+	return download(ctx, spkUrl, dir, newOptions(opts))
+}
+
+func download(ctx context.Context, spkUrl string, dir string, o options) (err error) {
 	switch url := strings.ToLower(spkUrl); {
+	case spkUrl == Stdin:
+		err = download_stdin(ctx, dir, o)
+	case strings.HasPrefix(url, "dir://"):
+		err = download_dir(ctx, spkUrl, dir, o)
+	case strings.HasPrefix(url, "oci://") || strings.HasPrefix(url, "oci+http://"):
+		err = download_oci(ctx, spkUrl, dir, o)
 	case strings.HasPrefix(url, "file://"):
-		err = download_file(ctx, spkUrl, dir)
+		err = download_file(ctx, spkUrl, dir, o)
+	case (strings.HasPrefix(url, "http://") || strings.HasPrefix(url, "https://")) && o.cacheDir != "":
+		err = download_cached(ctx, spkUrl, dir, o)
 	case strings.HasPrefix(url, "http://") || strings.HasPrefix(url, "https://"):
-		err = download_http(ctx, spkUrl, dir)
+		err = download_http(ctx, spkUrl, dir, o)
 	default:
 		err = fmt.Errorf("spk url is not support: %s", spkUrl)
 	}
 	return
 }
 
-func download_file(ctx context.Context, spkUrl string, dir string) (err error) {
+func download_file(ctx context.Context, spkUrl string, dir string, o options) (err error) {
 	slog.InfoContext(ctx, "download spk", "url", spkUrl)
 	defer func() {
 		if err != nil {
@@ -51,11 +61,11 @@ func download_file(ctx context.Context, spkUrl string, dir string) (err error) {
 	}
 	defer f.Close()
 
-	err = Extract(ctx, f, dir)
+	err = extract(ctx, f, dir, spkUrl, o)
 	return
 }
 
-func download_http(ctx context.Context, spkUrl string, dir string) (err error) {
+func download_http(ctx context.Context, spkUrl string, dir string, o options) (err error) {
 	slog.InfoContext(ctx, "download spk", "url", spkUrl)
 	defer func() {
 		if err != nil {
@@ -66,43 +76,43 @@ func download_http(ctx context.Context, spkUrl string, dir string) (err error) {
 	}()
 
 	var req *http.Request
-	if req, err = http.NewRequestWithContext(ctx, http.MethodGet, spkUrl, nil); err != nil {
+	if req, err = newRequest(ctx, http.MethodGet, spkUrl); err != nil {
 		return
 	}
-	req.Header.Set("accept", "text/html,application/xhtml+xml,application/xml;q=0.9,image/avif,image/webp,image/apng,*/*;q=0.8,application/signed-exchange;v=b3;q=0.7")
-	req.Header.Set("accept-encoding", "gzip, deflate, br, zstd")
-	req.Header.Set("accept-language", "zh-CN,zh;q=0.9,en;q=0.8,en-GB;q=0.7,en-US;q=0.6,zh-TW;q=0.5")
-	req.Header.Set("cache-control", "no-cache")
-	req.Header.Set("dnt", "1")
-	req.Header.Set("pragma", "no-cache")
-	req.Header.Set("priority", "u=0, i")
-	req.Header.Set("user-agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/143.0.0.0 Safari/537.36 Edg/143.0.0.0")
 
-	cli := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
 	var resp *http.Response
-	if resp, err = cli.Do(req); err != nil {
+	if resp, err = httpClient().Do(req); err != nil {
 		return
 	}
 	defer resp.Body.Close()
 
-	total := resp.ContentLength
-	current := int64(0)
-	pPrint := func(b []byte) (n int, err error) {
-		n = len(b)
-		cur := atomic.AddInt64(&current, int64(n))
-		fmt.Printf("\r%s %s/%s %.2f      ",
-			filepath.Base(spkUrl),
-			HumanBytes(cur),
-			HumanBytes(total),
-			float64(cur)*100/float64(total),
-		)
-		return
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status: %s", resp.Status)
 	}
 
-	err = Extract(ctx, io.MultiReader(resp.Body, Reader(pPrint)), dir)
+	t := o.track(ctx, spkUrl, 0, resp.ContentLength)
+	err = extract(ctx, io.TeeReader(resp.Body, t), dir, spkUrl, o)
+	t.Finish(err)
+	return
+}
+
+func newRequest(ctx context.Context, method, spkUrl string) (req *http.Request, err error) {
+	if req, err = http.NewRequestWithContext(ctx, method, spkUrl, nil); err != nil {
+		return
+	}
+	req.Header.Set("accept", "text/html,application/xhtml+xml,application/xml;q=0.9,image/avif,image/webp,image/apng,*/*;q=0.8,application/signed-exchange;v=b3;q=0.7")
+	req.Header.Set("accept-encoding", "gzip, deflate, br, zstd")
+	req.Header.Set("accept-language", "zh-CN,zh;q=0.9,en;q=0.8,en-GB;q=0.7,en-US;q=0.6,zh-TW;q=0.5")
+	req.Header.Set("cache-control", "no-cache")
+	req.Header.Set("dnt", "1")
+	req.Header.Set("pragma", "no-cache")
+	req.Header.Set("priority", "u=0, i")
+	req.Header.Set("user-agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/143.0.0.0 Safari/537.36 Edg/143.0.0.0")
 	return
 }
 
+func httpClient() *http.Client { return httpx.Client(0) }
+
 func allExists(ctx context.Context, dir string) bool {
 	files := []string{
 		filepath.Join(dir, "bin/bin/version"),
@@ -128,9 +138,9 @@ func allExists(ctx context.Context, dir string) bool {
 		case !stat.Mode().IsRegular():
 			err = fmt.Errorf("is not regular: %s", stat.Mode().Type().String())
 		case stat.Size() < 1024*1024*10:
-			err = fmt.Errorf("file size too small: %s", HumanBytes(stat.Size()))
+			err = fmt.Errorf("file size too small: %s", units.HumanBytes(stat.Size()))
 		default:
-			slog.DebugContext(ctx, "check spk", "perm", stat.Mode().Perm().String(), "size", HumanBytes(stat.Size()), "modtime", stat.ModTime(), "file", f)
+			slog.DebugContext(ctx, "check spk", "perm", stat.Mode().Perm().String(), "size", units.HumanBytes(stat.Size()), "modtime", stat.ModTime(), "file", f)
 			continue
 		}
 
@@ -140,17 +150,6 @@ func allExists(ctx context.Context, dir string) bool {
 	return true
 }
 
-func HumanBytes[T UintT | IntT](n T, prec ...int) string {
-	if f := float64(n); f >= 1024 {
-		for i, u := range slices.Backward([]rune("KMGTE")) {
-			if base := float64(int64(1) << (10 * (i + 1))); float64(n) >= base {
-				return fmt.Sprintf("%s %ciB", strconv.FormatFloat(f/base, 'f', cmp.Or(cmp.Or(prec...), 2), 64), u)
-			}
-		}
-	}
-	return fmt.Sprintf("%d bytes", n)
-}
-
 func PasswordMask(s string) string {
 	if len(s) <= 1 {
 		return strings.Repeat("*", len(s))
@@ -158,18 +157,14 @@ func PasswordMask(s string) string {
 	return s[:1] + strings.Repeat("*", len(s[1:]))
 }
 
-type UintT interface {
-	~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64
-}
-
-type IntT interface {
-	~int | ~int8 | ~int16 | ~int32 | ~int64
-}
-
 type Reader func([]byte) (int, error)
 
 func (r Reader) Read(p []byte) (int, error) { return r(p) }
 
+type Writer func([]byte) (int, error)
+
+func (w Writer) Write(p []byte) (int, error) { return w(p) }
+
 func errcheck(err error) error {
 	if os.IsNotExist(err) {
 		err = os.ErrNotExist
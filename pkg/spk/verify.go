@@ -0,0 +1,176 @@
+package spk
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Option Download 的可选项
+type Option func(o *options)
+
+type options struct {
+	sha256       string // 期望的 SHA-256(hex)
+	manifestUrl  string // 签名清单地址，签名位于 manifestUrl + ".sig"
+	manifestKey  string // 清单签名公钥(ed25519, base64)
+	cacheDir     string // SPK 缓存目录
+	refresh      bool   // 忽略缓存重新下载
+	filter       Filter // 提取的文件范围
+	reporters    []Reporter
+	keyringFiles []string // 群晖签名公钥环
+	strict       bool     // 拒绝没有签名或签名无效的 SPK
+
+	attempts       int           // 每个镜像的尝试次数
+	attemptTimeout time.Duration // 每次尝试的超时
+}
+
+func newOptions(opts []Option) (o options) {
+	for _, apply := range opts {
+		if apply != nil {
+			apply(&o)
+		}
+	}
+	return
+}
+
+// WithSha256 校验 SPK 文件的 SHA-256，为空不校验
+func WithSha256(digest string) Option {
+	return func(o *options) { o.sha256 = strings.ToLower(strings.TrimSpace(digest)) }
+}
+
+// WithManifest 从签名清单中取得 SPK 的 SHA-256 并校验。
+// 清单为 sha256sum 格式(每行"<hex>  <文件名>")，签名为清单内容的 ed25519 签名(base64)，位于 manifestUrl + ".sig"。
+func WithManifest(manifestUrl, publicKey string) Option {
+	return func(o *options) { o.manifestUrl, o.manifestKey = manifestUrl, publicKey }
+}
+
+var ErrDigestMismatch = errors.New("spk digest mismatch")
+
+// expectedDigest 期望的 SHA-256，固定值优先，其次从签名清单中读取，均未配置返回空
+func (o options) expectedDigest(ctx context.Context, spkUrl string) (digest string, err error) {
+	if o.sha256 != "" || o.manifestUrl == "" {
+		return o.sha256, nil
+	}
+
+	key, err := base64.StdEncoding.DecodeString(o.manifestKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return "", fmt.Errorf("manifest public key is invalid")
+	}
+
+	manifest, err := fetch(ctx, o.manifestUrl)
+	if err != nil {
+		return "", fmt.Errorf("fetch manifest: %w", err)
+	}
+	sigText, err := fetch(ctx, o.manifestUrl+".sig")
+	if err != nil {
+		return "", fmt.Errorf("fetch manifest signature: %w", err)
+	}
+	sig, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(sigText)))
+	if err != nil || !ed25519.Verify(ed25519.PublicKey(key), manifest, sig) {
+		return "", fmt.Errorf("manifest signature is invalid")
+	}
+
+	name := path.Base(spkUrl)
+	for s := bufio.NewScanner(bytes.NewReader(manifest)); s.Scan(); {
+		if fields := strings.Fields(s.Text()); len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == name {
+			slog.DebugContext(ctx, "manifest digest", "name", name, "sha256", fields[0])
+			return strings.ToLower(fields[0]), nil
+		}
+	}
+	return "", fmt.Errorf("manifest has no entry for %s", name)
+}
+
+// fetch 读取 file:// 或 http(s):// 的小文件
+func fetch(ctx context.Context, u string) (data []byte, err error) {
+	if strings.HasPrefix(strings.ToLower(u), "file://") {
+		return os.ReadFile(strings.TrimPrefix(u, "file://"))
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return
+	}
+	resp, err := httpClient().Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+}
+
+// extract 边下载边计算摘要，先解压到临时目录，整个文件读完且摘要匹配后才整体替换 dstDir，
+// 失败时不会在 dstDir 中留下任何写了一半的文件
+func extract(ctx context.Context, src io.Reader, dstDir, spkUrl string, o options) (err error) {
+	want, err := o.expectedDigest(ctx, spkUrl)
+	if err != nil {
+		return
+	}
+
+	if err = os.MkdirAll(filepath.Dir(dstDir), 0o777); err != nil {
+		return
+	}
+	staging, err := os.MkdirTemp(filepath.Dir(dstDir), "."+filepath.Base(dstDir)+"-staging-")
+	if err != nil {
+		return
+	}
+	defer os.RemoveAll(staging)
+
+	if err = extractSpk(ctx, src, staging, o); err != nil {
+		return
+	}
+
+	m, err := ReadManifest(staging)
+	if err != nil {
+		return
+	}
+	got := m.Sha256
+	slog.InfoContext(ctx, "spk digest", "sha256", got)
+	if want != "" && got != want {
+		return fmt.Errorf("%w: want %s, got %s", ErrDigestMismatch, want, got)
+	}
+
+	return swapDir(staging, dstDir)
+}
+
+// swapDir 用一次 rename 将 src 整个放到 dst：dst 已存在时先挪开，放入后再删除，
+// 中断时 dst 要么是旧目录要么是新目录，不会混合新旧文件
+func swapDir(src, dst string) (err error) {
+	if err = os.Chmod(src, 0o755); err != nil {
+		return
+	}
+
+	old := ""
+	if _, e := os.Lstat(dst); e == nil {
+		if old, err = os.MkdirTemp(filepath.Dir(dst), "."+filepath.Base(dst)+"-old-"); err != nil {
+			return
+		}
+		if err = os.Rename(dst, filepath.Join(old, "dir")); err != nil {
+			os.Remove(old)
+			return
+		}
+		defer os.RemoveAll(old)
+	}
+
+	if err = os.Rename(src, dst); err != nil {
+		if old != "" {
+			os.Rename(filepath.Join(old, "dir"), dst)
+		}
+		return
+	}
+	return syncDir(filepath.Dir(dst))
+}
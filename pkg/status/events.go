@@ -0,0 +1,79 @@
+package status
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// Event 状态变更
+type Event struct {
+	Key   string `json:"key"`
+	Value any    `json:"value"`
+}
+
+var (
+	subMu sync.Mutex
+	subs  = map[chan Event]struct{}{}
+)
+
+// Subscribe 订阅状态变更，返回的 cancel 用于取消订阅。订阅者处理不过来时丢弃事件
+func Subscribe() (events <-chan Event, cancel func()) {
+	ch := make(chan Event, 16)
+	subMu.Lock()
+	subs[ch] = struct{}{}
+	subMu.Unlock()
+	return ch, func() {
+		subMu.Lock()
+		delete(subs, ch)
+		subMu.Unlock()
+	}
+}
+
+func publish(key string, v any) {
+	subMu.Lock()
+	defer subMu.Unlock()
+	for ch := range subs {
+		select {
+		case ch <- Event{Key: key, Value: v}:
+		default:
+		}
+	}
+}
+
+// Events 以 SSE 推送状态变更，连接时先推送一次完整状态
+func Events() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+			return
+		}
+
+		events, cancel := Subscribe()
+		defer cancel()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		for key, v := range Snapshot() {
+			writeEvent(w, Event{Key: key, Value: v})
+		}
+		flusher.Flush()
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case e := <-events:
+				writeEvent(w, e)
+				flusher.Flush()
+			}
+		}
+	})
+}
+
+func writeEvent(w http.ResponseWriter, e Event) {
+	data, _ := json.Marshal(e.Value)
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Key, data)
+}
@@ -0,0 +1,40 @@
+package status
+
+import (
+	"encoding/json"
+	"maps"
+	"net/http"
+	"sync"
+)
+
+var (
+	mu    sync.RWMutex
+	state = map[string]any{}
+)
+
+// Set 设置状态中的一项，v 为 nil 时删除，并通知订阅者
+func Set(key string, v any) {
+	mu.Lock()
+	if v == nil {
+		delete(state, key)
+	} else {
+		state[key] = v
+	}
+	mu.Unlock()
+	publish(key, v)
+}
+
+// Snapshot 当前状态的浅拷贝
+func Snapshot() map[string]any {
+	mu.RLock()
+	defer mu.RUnlock()
+	return maps.Clone(state)
+}
+
+// Handler 以 JSON 输出当前状态
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(Snapshot())
+	})
+}
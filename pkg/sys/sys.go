@@ -4,6 +4,7 @@ import (
 	"io/fs"
 	"os"
 	"path/filepath"
+	"runtime"
 	"syscall"
 
 	"xlpdok/pkg/fo"
@@ -20,6 +21,9 @@ func Exec(runners ...Runner) (err error) {
 	return
 }
 
+// Steps 将多个 Runner 组合为一个
+func Steps(runners ...Runner) Runner { return func() error { return Exec(runners...) } }
+
 func Rmfile(file string) Runner {
 	return func() (err error) {
 		if err = os.Remove(file); os.IsNotExist(err) {
@@ -142,3 +146,34 @@ func RunAs(uid, gid int, runners ...Runner) func() error {
 		return Exec(runners...)
 	}
 }
+
+// Privileged 在当前线程临时恢复 root 有效身份执行 fn，用于 RunAs 期间需要 root 权限的操作(如 chown)。
+// 只修改当前线程的凭据，不影响其他线程；进程本身不是以 root 启动时无效。
+func Privileged(fn func() error) (err error) {
+	euid, egid := syscall.Geteuid(), syscall.Getegid()
+	if euid == 0 {
+		return fn()
+	}
+
+	runtime.LockOSThread()
+	if err = setresid(syscall.SYS_SETRESUID, 0); err != nil {
+		runtime.UnlockOSThread()
+		return
+	}
+	if err = setresid(syscall.SYS_SETRESGID, 0); err == nil {
+		err = fn()
+	}
+
+	// 恢复失败时保持线程锁定，goroutine 结束后该线程随之销毁
+	if setresid(syscall.SYS_SETRESGID, egid) == nil && setresid(syscall.SYS_SETRESUID, euid) == nil {
+		runtime.UnlockOSThread()
+	}
+	return
+}
+
+func setresid(trap uintptr, id int) error {
+	if _, _, e := syscall.RawSyscall(trap, ^uintptr(0), uintptr(id), ^uintptr(0)); e != 0 {
+		return e
+	}
+	return nil
+}
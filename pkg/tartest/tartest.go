@@ -0,0 +1,51 @@
+// Package tartest 构造测试用的 tar 归档，供 spk 和 unpack 的测试共用
+package tartest
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"testing"
+)
+
+// Entry 归档中的一个条目
+type Entry struct {
+	Name, Body, Link string
+	Type             byte
+}
+
+func File(name, body string) Entry     { return Entry{Name: name, Body: body, Type: tar.TypeReg} }
+func Symlink(name, link string) Entry  { return Entry{Name: name, Link: link, Type: tar.TypeSymlink} }
+func Hardlink(name, link string) Entry { return Entry{Name: name, Link: link, Type: tar.TypeLink} }
+
+// Tar 按顺序写入 entries，返回归档内容
+func Tar(t testing.TB, entries ...Entry) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	tw := tar.NewWriter(&b)
+	for _, e := range entries {
+		h := &tar.Header{Name: e.Name, Typeflag: e.Type, Linkname: e.Link, Mode: 0o644, Size: int64(len(e.Body))}
+		if err := tw.WriteHeader(h); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(e.Body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+// Gzip 压缩 data
+func Gzip(t testing.TB, data []byte) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	zw := gzip.NewWriter(&b)
+	zw.Write(data)
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
@@ -0,0 +1,37 @@
+package timex
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Duration 在JSON配置中可写成 "30s" 形式的字符串，也可写成纳秒数字
+type Duration time.Duration
+
+func (d Duration) Std() time.Duration { return time.Duration(d) }
+
+func (d Duration) Or(def time.Duration) time.Duration {
+	if d > 0 {
+		return time.Duration(d)
+	}
+	return def
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) { return json.Marshal(time.Duration(d).String()) }
+
+func (d *Duration) UnmarshalJSON(b []byte) (err error) {
+	var s string
+	if err = json.Unmarshal(b, &s); err != nil {
+		var n int64
+		if err = json.Unmarshal(b, &n); err == nil {
+			*d = Duration(n)
+		}
+		return
+	}
+
+	var v time.Duration
+	if v, err = time.ParseDuration(s); err == nil {
+		*d = Duration(v)
+	}
+	return
+}
@@ -0,0 +1,65 @@
+package units
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Bytes 字节数，JSON 中可写成数字或 "10GiB"、"500M" 形式的字符串
+type Bytes int64
+
+func (b *Bytes) UnmarshalJSON(data []byte) (err error) {
+	var s string
+	if err = json.Unmarshal(data, &s); err != nil {
+		var n int64
+		if err = json.Unmarshal(data, &n); err == nil {
+			*b = Bytes(n)
+		}
+		return
+	}
+	var n int64
+	if n, err = ParseBytes(s); err == nil {
+		*b = Bytes(n)
+	}
+	return
+}
+
+// ParseBytes 解析 "10GiB"、"10G"、"1.5T"、"1024" 形式的字节数，单位均按1024进制
+func ParseBytes(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	num := strings.TrimRightFunc(s, func(r rune) bool { return r < '0' || r > '9' && r != '.' })
+	unit := strings.TrimSuffix(strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(s[len(num):])), "B"), "I")
+	f, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return 0, fmt.Errorf("bytes is invalid: %s", s)
+	}
+
+	shift := strings.Index("KMGTPE", unit) + 1
+	if unit != "" && shift == 0 {
+		return 0, fmt.Errorf("bytes unit is invalid: %s", s)
+	}
+	return int64(f * float64(int64(1)<<(10*shift))), nil
+}
+
+func HumanBytes[T UintT | IntT](n T, prec ...int) string {
+	if f := float64(n); f >= 1024 {
+		for i, u := range slices.Backward([]rune("KMGTE")) {
+			if base := float64(int64(1) << (10 * (i + 1))); float64(n) >= base {
+				return fmt.Sprintf("%s %ciB", strconv.FormatFloat(f/base, 'f', cmp.Or(cmp.Or(prec...), 2), 64), u)
+			}
+		}
+	}
+	return fmt.Sprintf("%d bytes", n)
+}
+
+type UintT interface {
+	~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64
+}
+
+type IntT interface {
+	~int | ~int8 | ~int16 | ~int32 | ~int64
+}
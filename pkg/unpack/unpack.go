@@ -0,0 +1,218 @@
+package unpack
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"xlpdok/pkg/spk"
+
+	"github.com/bodgit/sevenzip"
+)
+
+// Config 下载完成后自动解压
+type Config struct {
+	DeleteArchive bool `json:"delete_archive,omitempty"` // 解压成功后删除压缩包
+}
+
+type format struct {
+	ext     string
+	extract func(ctx context.Context, src, dst string) error
+}
+
+// 按后缀从长到短匹配
+var formats = []format{
+	{".tar.gz", tarball(spk.Gzip)},
+	{".tar.xz", tarball(spk.Xz)},
+	{".tgz", tarball(spk.Gzip)},
+	{".txz", tarball(spk.Xz)},
+	{".tar", tarball(nil)},
+	{".zip", unzip},
+	{".7z", un7z},
+}
+
+func lookup(path string) (f format, ok bool) {
+	lower := strings.ToLower(path)
+	for _, f = range formats {
+		if strings.HasSuffix(lower, f.ext) {
+			return f, true
+		}
+	}
+	return
+}
+
+// Supported 是否为支持的压缩包
+func Supported(path string) bool {
+	_, ok := lookup(path)
+	return ok
+}
+
+// 解压中的临时目录前缀，解压完成后整体重命名为目标目录
+const stagingPrefix = ".unpacking-"
+
+// Staging 路径是否位于解压中的临时目录内。
+// 临时目录在下载目录中，监听下载目录时应忽略其中的文件，避免解压出的文件被当作新下载再次处理。
+func Staging(path string) bool {
+	for p := range strings.SplitSeq(filepath.ToSlash(path), "/") {
+		if strings.HasPrefix(p, stagingPrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Extract 解压到同级的同名文件夹(去掉压缩后缀)，返回解压目录。
+// 先解压到同级的临时目录，完成后再重命名，目标目录出现时内容已完整。
+func (c Config) Extract(ctx context.Context, path string) (dst string, err error) {
+	f, ok := lookup(path)
+	if !ok {
+		return "", fmt.Errorf("archive is not support: %s", path)
+	}
+
+	dst = path[:len(path)-len(f.ext)]
+	if _, e := os.Lstat(dst); e == nil {
+		return "", fmt.Errorf("extract target exists: %s", dst)
+	}
+
+	staging, err := os.MkdirTemp(filepath.Dir(dst), stagingPrefix)
+	if err != nil {
+		return
+	}
+	defer os.RemoveAll(staging)
+
+	if err = f.extract(ctx, path, staging); err != nil {
+		return "", err
+	}
+	if err = os.Chmod(staging, 0o755); err != nil {
+		return "", err
+	}
+	if err = os.Rename(staging, dst); err != nil {
+		return "", err
+	}
+
+	if c.DeleteArchive {
+		if e := os.Remove(path); e != nil {
+			slog.WarnContext(ctx, "remove archive", "path", path, "err", e)
+		}
+	}
+	return
+}
+
+// safeJoin 拼接压缩包中的路径，拒绝解析到 dst 之外的条目
+func safeJoin(dst, name string) (string, error) {
+	target := filepath.Join(dst, name)
+	if rel, err := filepath.Rel(dst, target); err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", fmt.Errorf("archive entry escapes target dir: %s", name)
+	}
+	return target, nil
+}
+
+func writeFile(target string, r io.Reader, mode fs.FileMode) (err error) {
+	if err = os.MkdirAll(filepath.Dir(target), 0o777); err != nil {
+		return
+	}
+	f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode.Perm()|0o600)
+	if err != nil {
+		return
+	}
+	_, err = io.Copy(f, r)
+	if ce := f.Close(); err == nil {
+		err = ce
+	}
+	return
+}
+
+func tarball(decoder spk.Decoder) func(ctx context.Context, src, dst string) error {
+	return func(ctx context.Context, src, dst string) error {
+		f, err := os.Open(src)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		return spk.Walk(ctx, f, func(r io.Reader, h *tar.Header) (err error) {
+			if err = ctx.Err(); err != nil {
+				return
+			}
+
+			target, err := safeJoin(dst, h.Name)
+			if err != nil {
+				return
+			}
+
+			switch h.Typeflag {
+			case tar.TypeDir:
+				return os.MkdirAll(target, 0o777)
+			case tar.TypeReg:
+				return writeFile(target, r, h.FileInfo().Mode())
+			default:
+				// 链接和设备文件可能指向解压目录之外，忽略
+				slog.DebugContext(ctx, "extract skip", "name", h.Name, "type", string(h.Typeflag))
+				return
+			}
+		}, decoder)
+	}
+}
+
+func unzip(ctx context.Context, src, dst string) error {
+	zr, err := zip.OpenReader(src)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	for _, zf := range zr.File {
+		if err = extractEntry(ctx, dst, zf.Name, zf.Mode(), zf.Open); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func un7z(ctx context.Context, src, dst string) error {
+	zr, err := sevenzip.OpenReader(src)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	for _, zf := range zr.File {
+		if err = extractEntry(ctx, dst, zf.Name, zf.Mode(), zf.Open); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func extractEntry(ctx context.Context, dst, name string, mode fs.FileMode, open func() (io.ReadCloser, error)) (err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+
+	target, err := safeJoin(dst, name)
+	if err != nil {
+		return
+	}
+
+	switch {
+	case mode.IsDir():
+		return os.MkdirAll(target, 0o777)
+	case mode.IsRegular():
+		r, e := open()
+		if e != nil {
+			return e
+		}
+		defer r.Close()
+		return writeFile(target, r, mode)
+	default:
+		slog.DebugContext(ctx, "extract skip", "name", name, "mode", mode)
+		return
+	}
+}
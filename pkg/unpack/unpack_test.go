@@ -0,0 +1,111 @@
+package unpack
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"xlpdok/pkg/tartest"
+)
+
+func writeTar(t *testing.T, path string, entries ...tartest.Entry) {
+	t.Helper()
+	if err := os.WriteFile(path, tartest.Tar(t, entries...), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSafeJoin(t *testing.T) {
+	dst := t.TempDir()
+	for _, name := range []string{"a", "a/b", "a/../b", "/abs"} {
+		if _, err := safeJoin(dst, name); err != nil {
+			t.Errorf("safeJoin(%q): %v", name, err)
+		}
+	}
+	for _, name := range []string{"..", "../a", "a/../../b"} {
+		if _, err := safeJoin(dst, name); err == nil {
+			t.Errorf("safeJoin(%q) should fail", name)
+		}
+	}
+}
+
+func TestExtract(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "movie.tar")
+	writeTar(t, archive,
+		tartest.File("movie/a.mkv", "video"),
+		tartest.Symlink("movie/link", "/etc/passwd"),
+		tartest.Hardlink("movie/hard", "../../etc/passwd"),
+	)
+
+	dst, err := Config{DeleteArchive: true}.Extract(context.Background(), archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dst != filepath.Join(dir, "movie") {
+		t.Fatalf("dst = %s", dst)
+	}
+	if data, err := os.ReadFile(filepath.Join(dst, "movie/a.mkv")); err != nil || string(data) != "video" {
+		t.Fatalf("extracted = %q, %v", data, err)
+	}
+	// 链接可能指向解压目录之外，不解压
+	for _, name := range []string{"movie/link", "movie/hard"} {
+		if _, err := os.Lstat(filepath.Join(dst, name)); !os.IsNotExist(err) {
+			t.Errorf("%s should be skipped: %v", name, err)
+		}
+	}
+	if _, err := os.Stat(archive); !os.IsNotExist(err) {
+		t.Errorf("archive should be deleted: %v", err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("staging dir left behind: %v", entries)
+	}
+
+	if _, err = (Config{}).Extract(context.Background(), filepath.Join(dir, "none.zip")); err == nil {
+		t.Fatal("missing archive should fail")
+	}
+}
+
+func TestExtractRejectsTraversal(t *testing.T) {
+	dir := t.TempDir()
+	tarFile := filepath.Join(dir, "a.tar")
+	writeTar(t, tarFile, tartest.File("ok", "1"), tartest.File("../escape", "x"))
+
+	zipFile := filepath.Join(dir, "b.zip")
+	var b bytes.Buffer
+	zw := zip.NewWriter(&b)
+	w, _ := zw.Create("../../escape")
+	w.Write([]byte("x"))
+	zw.Close()
+	if err := os.WriteFile(zipFile, b.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, archive := range []string{tarFile, zipFile} {
+		if _, err := (Config{}).Extract(context.Background(), archive); err == nil || !strings.Contains(err.Error(), "escapes") {
+			t.Errorf("%s: err = %v, want escape error", archive, err)
+		}
+	}
+
+	// 失败时不留下目标目录和临时目录，也没有写到外面
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 2 {
+		t.Errorf("entries = %v, want only the two archives", entries)
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(dir), "escape")); !os.IsNotExist(err) {
+		t.Errorf("file written outside: %v", err)
+	}
+}
+
+func TestStaging(t *testing.T) {
+	if !Staging("/downloads/" + stagingPrefix + "123/a.mkv") {
+		t.Error("file in staging dir should be ignored")
+	}
+	if Staging("/downloads/movie/a.mkv") || Staging("/downloads/unpacking-a.mkv") {
+		t.Error("regular download should not be ignored")
+	}
+}
@@ -0,0 +1,108 @@
+package watch
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// DefaultTempSuffixes 迅雷下载中的临时文件后缀
+var DefaultTempSuffixes = []string{".xltd", ".xlpd", ".downloading", ".tmp"}
+
+// 文件写入关闭后需要静默这么久才认为下载完成，避免分段写入时重复通知
+const settleDelay = 5 * time.Second
+
+// File 下载完成的文件
+type File struct {
+	Path     string
+	Size     int64
+	Duration time.Duration // 从首次发现文件到完成的耗时，未知时为0
+}
+
+// Completed 监听下载目录，当临时后缀消失(重命名)或非临时文件写入关闭后判定为下载完成
+func Completed(ctx context.Context, roots []string, tempSuffixes []string, handle func(File)) error {
+	isTemp := func(path string) bool {
+		return slices.ContainsFunc(tempSuffixes, func(suffix string) bool { return strings.HasSuffix(path, suffix) })
+	}
+
+	var (
+		mu      sync.Mutex
+		started = map[string]time.Time{}   // 文件首次出现的时间
+		moving  = map[uint32]time.Time{}   // 临时文件重命名中(cookie -> 开始时间)
+		pending = map[string]*time.Timer{} // 等待静默期结束的文件
+	)
+
+	emit := func(path string, start time.Time) {
+		end := time.Now()
+		mu.Lock()
+		defer mu.Unlock()
+		if t, find := pending[path]; find {
+			t.Stop()
+		}
+		pending[path] = time.AfterFunc(settleDelay, func() {
+			mu.Lock()
+			delete(pending, path)
+			delete(started, path)
+			mu.Unlock()
+
+			if ctx.Err() != nil {
+				return
+			}
+			var st syscall.Stat_t
+			if err := syscall.Stat(path, &st); err != nil || st.Mode&syscall.S_IFMT != syscall.S_IFREG {
+				return
+			}
+			f := File{Path: path, Size: st.Size}
+			if !start.IsZero() {
+				f.Duration = end.Sub(start)
+			}
+			handle(f)
+		})
+	}
+
+	return Watch(ctx, roots, syscall.IN_CREATE|syscall.IN_CLOSE_WRITE|syscall.IN_MOVED_FROM|syscall.IN_MOVED_TO|syscall.IN_DELETE, func(ev Event) {
+		if ev.IsDir() {
+			return
+		}
+
+		mu.Lock()
+		start := started[ev.Path]
+		switch {
+		case ev.Has(syscall.IN_CREATE):
+			if start.IsZero() {
+				started[ev.Path] = time.Now()
+			}
+			mu.Unlock()
+		case ev.Has(syscall.IN_MOVED_FROM):
+			delete(started, ev.Path)
+			if isTemp(ev.Path) {
+				moving[ev.Cookie] = start
+			}
+			mu.Unlock()
+		case ev.Has(syscall.IN_MOVED_TO):
+			start, find := moving[ev.Cookie]
+			delete(moving, ev.Cookie)
+			mu.Unlock()
+			if find && !isTemp(ev.Path) {
+				emit(ev.Path, start)
+			}
+		case ev.Has(syscall.IN_CLOSE_WRITE):
+			mu.Unlock()
+			if !isTemp(ev.Path) {
+				emit(ev.Path, start)
+			}
+		case ev.Has(syscall.IN_DELETE):
+			delete(started, ev.Path)
+			if t, find := pending[ev.Path]; find {
+				t.Stop()
+				delete(pending, ev.Path)
+			}
+			mu.Unlock()
+		default:
+			mu.Unlock()
+		}
+	})
+}
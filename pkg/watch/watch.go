@@ -0,0 +1,128 @@
+package watch
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// Event inotify 事件
+type Event struct {
+	Path   string // 完整路径
+	Mask   uint32 // syscall.IN_* 组合
+	Cookie uint32 // 同一次重命名的 IN_MOVED_FROM 和 IN_MOVED_TO 相同
+}
+
+func (e Event) Has(mask uint32) bool { return e.Mask&mask != 0 }
+func (e Event) IsDir() bool          { return e.Mask&syscall.IN_ISDIR != 0 }
+
+// 目录树变化时需要的事件，总是会监听
+const treeMask = syscall.IN_CREATE | syscall.IN_MOVED_TO | syscall.IN_DELETE_SELF | syscall.IN_MOVE_SELF
+
+// Watcher 递归监听若干目录，新建或移入的子目录会自动加入监听
+type Watcher struct {
+	mask uint32
+	fd   int
+	f    *os.File
+
+	mu    sync.Mutex
+	paths map[int32]string
+}
+
+// Watch 递归监听 roots 直到 ctx 结束，mask 为额外关心的 syscall.IN_* 事件
+func Watch(ctx context.Context, roots []string, mask uint32, handle func(Event)) (err error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return os.NewSyscallError("inotify_init1", err)
+	}
+
+	w := &Watcher{mask: mask | treeMask, fd: fd, f: os.NewFile(uintptr(fd), "inotify"), paths: map[int32]string{}}
+	defer w.f.Close()
+
+	for _, root := range roots {
+		if err = w.addTree(ctx, root); err != nil {
+			return
+		}
+	}
+
+	stop := context.AfterFunc(ctx, func() { w.f.Close() })
+	defer stop()
+
+	buf := make([]byte, 64*1024)
+	for {
+		n, e := w.f.Read(buf)
+		if e != nil {
+			if ctx.Err() != nil || errors.Is(e, os.ErrClosed) {
+				return nil
+			}
+			return e
+		}
+
+		for off := 0; off+syscall.SizeofInotifyEvent <= n; {
+			raw := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[off]))
+			name := strings.TrimRight(string(buf[off+syscall.SizeofInotifyEvent:off+syscall.SizeofInotifyEvent+int(raw.Len)]), "\x00")
+			off += syscall.SizeofInotifyEvent + int(raw.Len)
+
+			if raw.Mask&syscall.IN_Q_OVERFLOW != 0 {
+				slog.WarnContext(ctx, "inotify queue overflow, some events are lost")
+				continue
+			}
+
+			w.mu.Lock()
+			dir, find := w.paths[raw.Wd]
+			if raw.Mask&syscall.IN_IGNORED != 0 {
+				delete(w.paths, raw.Wd)
+			}
+			w.mu.Unlock()
+			if !find {
+				continue
+			}
+
+			ev := Event{Path: dir, Mask: raw.Mask, Cookie: raw.Cookie}
+			if name != "" {
+				ev.Path = filepath.Join(dir, name)
+			}
+
+			if ev.IsDir() && ev.Has(syscall.IN_CREATE|syscall.IN_MOVED_TO) {
+				if e := w.addTree(ctx, ev.Path); e != nil {
+					slog.DebugContext(ctx, "watch dir", "path", ev.Path, "err", e)
+				}
+			}
+
+			if ev.Has(w.mask) {
+				handle(ev)
+			}
+		}
+	}
+}
+
+func (w *Watcher) addTree(ctx context.Context, root string) error {
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+
+		wd, err := syscall.InotifyAddWatch(w.fd, path, w.mask)
+		if err != nil {
+			return os.NewSyscallError("inotify_add_watch", err)
+		}
+		w.mu.Lock()
+		w.paths[int32(wd)] = path
+		w.mu.Unlock()
+		slog.DebugContext(ctx, "watch dir", "path", path)
+		return nil
+	})
+}
@@ -0,0 +1,109 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"xlpdok/pkg/httpx"
+	"xlpdok/pkg/timex"
+)
+
+const (
+	HeaderEvent     = "X-Xlpdok-Event"
+	HeaderSignature = "X-Xlpdok-Signature" // sha256=<hex(hmac_sha256(secret, body))>
+)
+
+const defaultRetries = 3
+
+// Webhook 回调地址配置
+type Webhook struct {
+	URL     string         `json:"url"`
+	Secret  string         `json:"secret,omitempty"`  // HMAC 签名密钥，为空则不签名
+	Retries *int           `json:"retries,omitempty"` // 失败重试次数，未设置时为3，0不重试
+	Timeout timex.Duration `json:"timeout,omitempty"` // 单次请求超时，默认10s
+}
+
+// Completed 下载完成的回调内容
+type Completed struct {
+	Event    string    `json:"event"`
+	Path     string    `json:"path"`
+	Size     int64     `json:"size"`
+	Duration float64   `json:"duration"` // 秒
+	Time     time.Time `json:"time"`
+}
+
+// Send 将 payload 以 JSON 发送到所有回调地址，失败按指数退避重试
+func Send(ctx context.Context, hooks []Webhook, event string, payload any) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		slog.ErrorContext(ctx, "webhook marshal", "event", event, "err", err)
+		return
+	}
+
+	for _, h := range hooks {
+		retries := defaultRetries
+		if h.Retries != nil {
+			retries = max(*h.Retries, 0)
+		}
+		for i := 0; ; i++ {
+			if err = post(ctx, h, event, body); err == nil {
+				slog.DebugContext(ctx, "webhook sent", "event", event, "url", h.URL)
+				break
+			}
+
+			if i >= retries || ctx.Err() != nil {
+				slog.WarnContext(ctx, "webhook fail", "event", event, "url", h.URL, "attempts", i+1, "err", err)
+				break
+			}
+
+			slog.DebugContext(ctx, "webhook retry", "event", event, "url", h.URL, "attempt", i+1, "err", err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(time.Second << i):
+			}
+		}
+	}
+}
+
+func post(ctx context.Context, h Webhook, event string, body []byte) (err error) {
+	ctx, cancel := context.WithTimeout(ctx, h.Timeout.Or(10*time.Second))
+	defer cancel()
+
+	var req *http.Request
+	if req, err = http.NewRequestWithContext(ctx, http.MethodPost, h.URL, bytes.NewReader(body)); err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEvent, event)
+	if h.Secret != "" {
+		req.Header.Set(HeaderSignature, "sha256="+Sign(h.Secret, body))
+	}
+
+	var resp *http.Response
+	if resp, err = httpx.Client(0).Do(req); err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode/100 != 2 {
+		err = fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	return
+}
+
+// Sign 计算 body 的 HMAC-SHA256 签名(hex)
+func Sign(secret string, body []byte) string {
+	m := hmac.New(sha256.New, []byte(secret))
+	m.Write(body)
+	return hex.EncodeToString(m.Sum(nil))
+}
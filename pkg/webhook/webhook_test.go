@@ -0,0 +1,66 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestRetries(t *testing.T) {
+	tests := []struct {
+		retries *int
+		fail    int32 // 前 fail 次请求失败
+		want    int32
+	}{
+		{retries: intp(0), fail: 5, want: 1},
+		{retries: intp(1), fail: 5, want: 2},
+		{retries: nil, fail: 0, want: 1},
+		{retries: intp(-1), fail: 5, want: 1},
+	}
+
+	for _, tt := range tests {
+		var calls atomic.Int32
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if calls.Add(1) <= tt.fail {
+				w.WriteHeader(http.StatusBadGateway)
+			}
+		}))
+
+		Send(context.Background(), []Webhook{{URL: srv.URL, Retries: tt.retries}}, "test", map[string]any{})
+		srv.Close()
+		if got := calls.Load(); got != tt.want {
+			t.Errorf("retries=%v: requests = %d, want %d", tt.retries, got, tt.want)
+		}
+	}
+}
+
+func TestSignature(t *testing.T) {
+	var got http.Header
+	var body []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, body = r.Header.Clone(), must(io.ReadAll(r.Body))
+	}))
+	defer srv.Close()
+
+	Send(context.Background(), []Webhook{{URL: srv.URL, Secret: "s3cret"}}, "download.completed", Completed{Event: "download.completed", Path: "/a"})
+	if got.Get(HeaderEvent) != "download.completed" || got.Get(HeaderSignature) != "sha256="+Sign("s3cret", body) {
+		t.Fatalf("headers = %v", got)
+	}
+	var c Completed
+	if err := json.Unmarshal(body, &c); err != nil || c.Path != "/a" {
+		t.Fatalf("body = %s, %v", body, err)
+	}
+}
+
+func intp(n int) *int { return &n }
+
+func must[T any](v T, err error) T {
+	if err != nil {
+		panic(err)
+	}
+	return v
+}